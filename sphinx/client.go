@@ -0,0 +1,637 @@
+package sphinx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client 管理到 searchd 的连接和协议协商，所有方法都可以在多个 goroutine 中并发调用。
+// 查询设置保存在 SearchRequest 中，通过 Search 一次发送一条或多条
+type Client struct {
+	mu         sync.Mutex
+	cfg        clientConfig
+	dialect    Dialect
+	ver        uint16
+	negotiated bool
+	pinned     bool // 版本由 SetProtocolVersion 指定，切换服务端时不重新协商
+	persist    bool
+	warning    string
+	pool       pool
+}
+
+// clientConfig 建立连接和读写使用的参数，每次请求开始时复制一份，请求过程中的 Set* 调用不影响它
+type clientConfig struct {
+	network      string
+	address      string
+	conntimeout  int
+	readtimeout  time.Duration
+	writetimeout time.Duration
+	maxresponse  uint32
+}
+
+// endpoint 标识连接指向的 searchd，用于判断池中的连接是否还能复用
+func (cfg clientConfig) endpoint() string {
+	return cfg.network + "://" + cfg.address
+}
+
+// searchdConn 一个已完成握手的连接，persistent 表示已发送 PERSIST 命令，reused 表示取自连接池中的空闲连接
+type searchdConn struct {
+	net.Conn
+	endpoint   string
+	persistent bool
+	reused     bool
+	created    time.Time
+	idleSince  time.Time
+}
+
+func NewClient() *Client {
+	return &Client{
+		cfg: clientConfig{
+			network:     "tcp",
+			address:     "127.0.0.1:3312",
+			conntimeout: 2,
+			maxresponse: defaultMaxResponseSize,
+		},
+		dialect: DialectAuto,
+		ver:     VER_COMMAND_SEARCH,
+		pool:    pool{maxIdle: defaultMaxIdleConns},
+	}
+}
+
+func (c *Client) config() clientConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfg
+}
+
+// SetServer 设置 searchd 的地址。host 可以是主机名、IPv4 或 IPv6 地址（可带方括号），
+// 也可以是 unix:///var/run/searchd.sock 形式或以 / 开头的 unix socket 路径，此时忽略 port
+func (c *Client) SetServer(host string, port int) {
+	network, address := "tcp", ""
+
+	switch {
+	//if ( substr ( $host, 0, 7 )=="unix://" ) { $this->_path = $host; return; }
+	case strings.HasPrefix(host, "unix://"):
+		network, address = "unix", strings.TrimPrefix(host, "unix://")
+	//if ( $host[0] == '/') { $this->_path = 'unix://' . $host; return; }
+	case strings.HasPrefix(host, "/"):
+		network, address = "unix", host
+	default:
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		address = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setEndpointLocked(network, address)
+}
+
+// SetEndpoint 直接指定连接使用的网络和地址，network 支持 tcp、tcp4、tcp6 和 unix，
+// address 的格式与 net.Dial 相同，例如 "[::1]:9312" 或 "/var/run/searchd.sock"
+func (c *Client) SetEndpoint(network string, address string) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return fmt.Errorf("%s, %w: network %s", "SetEndpoint", ErrParameter, network)
+	}
+	if address == "" {
+		return fmt.Errorf("%s, %w: empty address", "SetEndpoint", ErrParameter)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setEndpointLocked(network, address)
+	return nil
+}
+
+// setEndpointLocked 切换服务端地址。DialectAuto 下新的服务端可能是另一个版本，
+// 协商结果作废，下一次 search 重新从最新的布局开始协商
+func (c *Client) setEndpointLocked(network string, address string) {
+	if c.cfg.network == network && c.cfg.address == address {
+		return
+	}
+	c.cfg.network = network
+	c.cfg.address = address
+
+	if c.dialect == DialectAuto && !c.pinned {
+		c.ver = VER_COMMAND_SEARCH
+		c.negotiated = false
+	}
+}
+
+func (c *Client) GetConnTimeout() int {
+	return c.config().conntimeout
+}
+
+func (c *Client) SetConnTimeout(timeout int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg.conntimeout = timeout
+}
+
+// SetMaxResponseSize 设置允许的最大响应包长度（字节），防止异常的长度头导致一次分配过多内存
+func (c *Client) SetMaxResponseSize(size uint32) error {
+	if size == 0 {
+		return fmt.Errorf("%s, %w: %d", "SetMaxResponseSize", ErrParameter, size)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg.maxresponse = size
+	return nil
+}
+
+func (c *Client) GetMaxResponseSize() uint32 {
+	return c.config().maxresponse
+}
+
+// SetReadTimeout 设置每次等待 searchd 响应的超时时间，0 表示不限制
+func (c *Client) SetReadTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg.readtimeout = timeout
+}
+
+func (c *Client) GetReadTimeout() time.Duration {
+	return c.config().readtimeout
+}
+
+// SetWriteTimeout 设置每次向 searchd 发送请求的超时时间，0 表示不限制
+func (c *Client) SetWriteTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg.writetimeout = timeout
+}
+
+func (c *Client) GetWriteTimeout() time.Duration {
+	return c.config().writetimeout
+}
+
+// SetDialect 指定 searchd 的协议方言，DialectAuto 时自动协商
+func (c *Client) SetDialect(dialect Dialect) error {
+	ver, ok := dialectVersions[dialect]
+	if !ok {
+		return fmt.Errorf("%s, %w: %d", "SetDialect", ErrParameter, dialect)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialect = dialect
+	c.ver = ver
+	c.negotiated = dialect != DialectAuto
+	c.pinned = false
+	return nil
+}
+
+func (c *Client) GetDialect() Dialect {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dialect
+}
+
+// SetProtocolVersion 直接指定 search 协议版本，
+// 范围为 VER_COMMAND_SEARCH_MIN 到 VER_COMMAND_SEARCH，更高的版本才能使用字符串过滤等新特性。
+// 没有对应请求布局的版本按不高于它的最高布局发送，GetProtocolVersion 返回实际使用的版本
+func (c *Client) SetProtocolVersion(ver uint16) error {
+	if ver < VER_COMMAND_SEARCH_MIN || ver > VER_COMMAND_SEARCH {
+		return fmt.Errorf("%s, %w: 0x%x", "SetProtocolVersion", ErrParameter, ver)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ver = searchLayout(int(ver))
+	c.negotiated = true
+	c.pinned = true
+	return nil
+}
+
+// GetProtocolVersion 返回当前使用的 search 协议版本，DialectAuto 时为协商后的版本
+func (c *Client) GetProtocolVersion() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ver
+}
+
+// GetLastWarning 返回最近一次命令中 searchd 给出的命令级警告。
+// 多个 goroutine 共用一个 Client 时“最近一次”没有意义，search 的警告请读取 Result.Warning
+func (c *Client) GetLastWarning() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.warning
+}
+
+// deadline 根据超时时间和 ctx 的截止时间计算 deadline，取较早的一个，都没有时表示不限制
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	var t time.Time
+	if timeout > 0 {
+		t = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (t.IsZero() || d.Before(t)) {
+		t = d
+	}
+	return t
+}
+
+// watchConn 在 ctx 被取消时立即让 conn 上阻塞的读写返回，调用返回的 stop 结束监听。
+// stop 会等监听的 goroutine 退出，如果它已经设置了过期的 deadline 就清除掉，
+// 保证连接归还到连接池之后不会再被这次请求的 ctx 影响
+func watchConn(ctx context.Context, conn net.Conn) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	exited := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+			exited <- true
+		case <-done:
+			exited <- false
+		}
+	}()
+	return func() {
+		close(done)
+		if <-exited {
+			conn.SetDeadline(time.Time{})
+		}
+	}
+}
+
+// ctxError ctx 已经结束时把 ctx.Err() 附加到 err 上，
+// errors.Is 对 context.Canceled/context.DeadlineExceeded 和原来的错误（如 ErrTimeout）都成立
+func ctxError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		return &contextError{ctxErr: ctx.Err(), err: err}
+	}
+	return err
+}
+
+type contextError struct {
+	ctxErr error
+	err    error
+}
+
+func (e *contextError) Error() string {
+	return e.ctxErr.Error() + ": " + e.err.Error()
+}
+
+func (e *contextError) Unwrap() error {
+	return e.err
+}
+
+func (e *contextError) Is(target error) bool {
+	return target == e.ctxErr
+}
+
+// ioError 对读写错误归类。ctx 被取消时返回 context.Canceled，此时的超时只是 watchConn 设置的过期 deadline；
+// 其他超时（包括 ctx 到期）统一返回 ErrTimeout，其余错误用 kind 包装
+func ioError(ctx context.Context, kind error, op string, err error) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return fmt.Errorf("%w: %s: %s", context.Canceled, op, err.Error())
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return fmt.Errorf("%w: %s: %s", ErrTimeout, op, err.Error())
+	}
+	return fmt.Errorf("%w: %s: %s", kind, op, err.Error())
+}
+
+// connect 建立连接并完成握手，persist 为 true 时发送 PERSIST 命令让 searchd 保持连接
+func connect(ctx context.Context, cfg clientConfig, persist bool) (*searchdConn, error) {
+
+	//1.建立一个链接（Dial拨号
+	dialer := net.Dialer{Timeout: time.Second * time.Duration(cfg.conntimeout)}
+	conn, err := dialer.DialContext(ctx, cfg.network, cfg.address)
+
+	if err != nil {
+		return nil, ioError(ctx, ErrNoClient, "dial", err)
+	}
+
+	// deadline 要在 watchConn 之前设置，否则会覆盖掉 ctx 已经取消时它设置的过期 deadline
+	conn.SetReadDeadline(deadline(ctx, cfg.readtimeout))
+	conn.SetWriteDeadline(deadline(ctx, cfg.writetimeout))
+
+	stop := watchConn(ctx, conn)
+	defer stop()
+
+	if err := ctx.Err(); err != nil {
+		conn.Close()
+		return nil, ioError(ctx, ErrNoClient, "handshake", err)
+	}
+
+	version := make([]byte, 4)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		conn.Close()
+		return nil, ioError(ctx, ErrNoClient, "handshake", err)
+	}
+
+	//if ( $v<1 ) "expected searchd protocol version 1+"
+	if binary.BigEndian.Uint32(version) < 1 {
+		conn.Close()
+		return nil, fmt.Errorf("%w:%s %b", ErrVersions, "Connect response", version)
+	}
+
+	if _, err := conn.Write([]byte{0x00, 0x00, 0x00, 0x01}); err != nil {
+		conn.Close()
+		return nil, ioError(ctx, ErrNoClient, "handshake", err)
+	}
+
+	if persist {
+		//$req = pack ( "nnNN", SEARCHD_COMMAND_PERSIST, 0, 4, 1 );
+		req := bytes.NewBuffer([]byte{})
+		binary.Write(req, binary.BigEndian, uint16(SEARCHD_COMMAND_PERSIST))
+		binary.Write(req, binary.BigEndian, uint16(0))
+		binary.Write(req, binary.BigEndian, uint32(4))
+		binary.Write(req, binary.BigEndian, uint32(1))
+
+		if _, err := conn.Write(req.Bytes()); err != nil {
+			conn.Close()
+			return nil, ioError(ctx, ErrNoClient, "persist", err)
+		}
+	}
+
+	return &searchdConn{Conn: conn, endpoint: cfg.endpoint(), persistent: persist, created: time.Now()}, nil
+}
+
+// Open 打开持久连接并启用连接池，之后的命令都从池中取用连接直到 Close。
+// 池的大小和连接的回收由 SetMaxOpenConns、SetMaxIdleConns、SetConnMaxIdleTime 和 SetConnMaxLifetime 控制
+func (c *Client) Open() error {
+	return c.OpenContext(context.Background())
+}
+
+// OpenContext 同 Open，ctx 取消时中断连接和读写
+func (c *Client) OpenContext(ctx context.Context) error {
+	c.mu.Lock()
+	if c.persist {
+		c.mu.Unlock()
+		return fmt.Errorf("%w:%s", ErrParameter, "already connected")
+	}
+	c.persist = true
+	cfg := c.cfg
+	c.mu.Unlock()
+
+	conn, err := c.acquire(ctx, cfg)
+	if err != nil {
+		c.mu.Lock()
+		c.persist = false
+		c.mu.Unlock()
+		return ctxError(ctx, err)
+	}
+
+	c.release(conn, true)
+	return nil
+}
+
+// Close 关闭连接池中的空闲连接并停用连接池，正在使用中的连接在请求结束后关闭
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if !c.persist {
+		c.mu.Unlock()
+		return fmt.Errorf("%w:%s", ErrParameter, "not connected")
+	}
+	c.persist = false
+	idle := c.pool.idle
+	c.pool.idle = nil
+	for range idle {
+		c.closedLocked()
+	}
+	c.mu.Unlock()
+
+	var err error
+	for _, conn := range idle {
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// request 发送一个完整的命令包并读取响应，返回响应体和 searchd 给出的命令级警告。
+// 非持久模式下每次请求都新建连接并在结束后关闭，
+// 持久模式下从连接池取用连接，空闲连接已被服务端断开时自动重连一次
+func (c *Client) request(ctx context.Context, command int, ver uint16, req []byte) ([]byte, string, error) {
+
+	cfg := c.config()
+
+	conn, err := c.acquire(ctx, cfg)
+	if err != nil {
+		return nil, "", ctxError(ctx, err)
+	}
+
+	response, warning, err := exchange(ctx, conn, cfg, command, ver, req)
+
+	if conn.reused && errors.Is(err, ErrConnLost) && ctx.Err() == nil {
+		conn.Close()
+
+		if conn, err = c.dial(ctx, cfg, true); err != nil {
+			return nil, "", ctxError(ctx, err)
+		}
+		response, warning, err = exchange(ctx, conn, cfg, command, ver, req)
+	}
+
+	// searchd 返回的错误状态不影响后续通讯，其他错误之后连接上的数据流已经不可信
+	var serr *SearchdError
+	c.release(conn, err == nil || errors.As(err, &serr))
+
+	c.mu.Lock()
+	c.warning = warning
+	c.mu.Unlock()
+
+	return response, warning, ctxError(ctx, err)
+}
+
+func exchange(ctx context.Context, conn net.Conn, cfg clientConfig, command int, ver uint16, req []byte) ([]byte, string, error) {
+
+	// 读超时从发送请求之前开始计算。deadline 要在 watchConn 之前设置，
+	// 否则会覆盖掉 ctx 已经取消时它设置的过期 deadline，读响应时一直等到 searchd 回复
+	conn.SetWriteDeadline(deadline(ctx, cfg.writetimeout))
+	conn.SetReadDeadline(deadline(ctx, cfg.readtimeout))
+
+	stop := watchConn(ctx, conn)
+	defer stop()
+
+	if err := ctx.Err(); err != nil {
+		return nil, "", ioError(ctx, ErrConnLost, "write request", err)
+	}
+
+	//header
+	// 8字节 （(known searchd commands) + （current client-side command implementation versions） + 包长度）
+	headr := bytes.NewBuffer([]byte{})
+	binary.Write(headr, binary.BigEndian, uint16(command))
+	binary.Write(headr, binary.BigEndian, ver)
+	binary.Write(headr, binary.BigEndian, uint32(len(req)))
+	headr.Write(req)
+
+	if _, err := conn.Write(headr.Bytes()); err != nil {
+		return nil, "", ioError(ctx, ErrConnLost, "write request", err)
+	}
+
+	return getResponse(ctx, conn, cfg.maxresponse, ver)
+}
+
+func getResponse(ctx context.Context, conn net.Conn, maxresponse uint32, client_ver uint16) ([]byte, string, error) {
+
+	header := make([]byte, 4)
+
+	// 持久连接被服务端关闭时，写入通常还能成功，要到读响应头时才会发现
+	if _, err := io.ReadFull(conn, header[:2]); err != nil {
+		return nil, "", ioError(ctx, ErrConnLost, "read header", err)
+	}
+	status := binary.BigEndian.Uint16(header[:2])
+
+	if _, err := io.ReadFull(conn, header[:2]); err != nil {
+		return nil, "", ioError(ctx, ErrMalformedResponse, "read header", err)
+	}
+	ver := binary.BigEndian.Uint16(header[:2])
+
+	if _, err := io.ReadFull(conn, header[:4]); err != nil {
+		return nil, "", ioError(ctx, ErrMalformedResponse, "read header", err)
+	}
+	lens := binary.BigEndian.Uint32(header[:4])
+
+	if lens > maxresponse {
+		return nil, "", &ResponseSizeError{Size: lens, Limit: maxresponse}
+	}
+
+	// 按块读取，内存随实际收到的数据增长，而不是按长度头一次性分配
+	body := bytes.NewBuffer([]byte{})
+	if n, err := io.CopyN(body, conn, int64(lens)); err != nil {
+		return nil, "", ioError(ctx, ErrMalformedResponse, fmt.Sprintf("read %d of %d body bytes", n, lens), err)
+	}
+	buff := body.Bytes()
+
+	if status == SEARCHD_WARNING {
+		r := newReader(buff)
+		warning := r.string("warning")
+		if r.err != nil {
+			return nil, "", r.err
+		}
+		return buff[r.p:], warning, nil
+	}
+
+	if status == SEARCHD_ERROR || status == SEARCHD_RETRY {
+		r := newReader(buff)
+		message := r.string("error message")
+		if r.err != nil {
+			return nil, "", r.err
+		}
+		return nil, "", &SearchdError{Status: int(status), Message: message, QueryIndex: -1}
+	}
+
+	if status != SEARCHD_OK {
+		return nil, "", &SearchdError{Status: int(status), Message: "unknown status code", QueryIndex: -1}
+	}
+
+	if ver < client_ver {
+		return nil, "", fmt.Errorf("%w: %s", ErrRetryMessage, "client_ver error")
+	}
+
+	return buff, "", nil
+
+}
+
+// Search 一次性发送 reqs 中的所有查询，按顺序返回每条查询的 Result。
+// 单条查询的失败只体现在它自己 Result 的 Status/Error 上，
+// 只有连接或协议层面的错误才会返回 error
+func (c *Client) Search(reqs ...SearchRequest) ([]Result, error) {
+	return c.SearchContext(context.Background(), reqs...)
+}
+
+// SearchContext 同 Search，ctx 取消时中断连接和读写，ctx 的截止时间同时用作 searchd 端的 max_query_time
+func (c *Client) SearchContext(ctx context.Context, reqs ...SearchRequest) ([]Result, error) {
+
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("%w:%s", ErrParameter, "no queries defined")
+	}
+
+	ver := c.GetProtocolVersion()
+	response, warning, err := c.sendQueries(ctx, ver, reqs)
+
+	if err != nil && c.negotiate(err, ver) {
+		ver = c.GetProtocolVersion()
+		response, warning, err = c.sendQueries(ctx, ver, reqs)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.ver == ver {
+		c.negotiated = true
+	}
+	c.mu.Unlock()
+
+	return parseSearchResponse(response, reqs, warning)
+}
+
+// sendQueries 按协议版本 ver 编码 reqs 并发送。
+// ctx 有截止时间时，把剩余时间作为 max_query_time，让 searchd 不再做我们等不到的工作
+func (c *Client) sendQueries(ctx context.Context, ver uint16, reqs []SearchRequest) ([]byte, string, error) {
+
+	dialect := c.GetDialect()
+
+	resqBuff := bytes.NewBuffer([]byte{})
+	binary.Write(resqBuff, binary.BigEndian, uint32(len(reqs)))
+
+	for _, q := range reqs {
+		if d, ok := ctx.Deadline(); ok {
+			left := time.Until(d).Milliseconds()
+			if left < 1 {
+				left = 1
+			}
+			if q.maxquerytime == 0 || uint(left) < q.maxquerytime {
+				q.maxquerytime = uint(left)
+			}
+		}
+
+		req, err := q.encode(ver, dialect)
+		if err != nil {
+			return nil, "", err
+		}
+		resqBuff.Write(req)
+	}
+
+	return c.request(ctx, SEARCHD_COMMAND_SEARCH, ver, resqBuff.Bytes())
+}
+
+// negotiate 在服务端拒绝协议版本 ver 时，降级到不高于它回报的版本的最高布局，返回是否需要重发
+func (c *Client) negotiate(err error, ver uint16) bool {
+	var serr *SearchdError
+	if !errors.As(err, &serr) {
+		return false
+	}
+
+	m := daemonVersionRe.FindStringSubmatch(serr.Message)
+	if m == nil {
+		return false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	if major != 1 || minor > 0xff {
+		return false
+	}
+	daemon := searchLayout(major<<8 | minor)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 并发的请求已经完成了降级
+	if c.ver < ver {
+		return true
+	}
+	if c.negotiated || daemon == 0 || daemon >= ver {
+		return false
+	}
+	c.ver = daemon
+	c.negotiated = true
+	return true
+}
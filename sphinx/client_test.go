@@ -0,0 +1,147 @@
+package sphinx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ctx 在请求结束后立即取消时，监听的 goroutine 不能在 stop 返回之后再改动连接的 deadline
+func TestWatchConnStopWaits(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	buf := make([]byte, 1)
+	for i := 0; i < 1000; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		stop := watchConn(ctx, conn)
+		cancel()
+		stop()
+
+		go peer.Write([]byte{1})
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("iteration %d: %v", i, err)
+		}
+	}
+}
+
+// versionedReply 模拟只支持到 daemon 版本的 searchd，拒绝更高的客户端版本，
+// 并把收到的 search 请求版本记录在 seen 中
+func versionedReply(daemon uint16, seen *uint32) handler {
+	return func(cmd uint16, ver uint16, body []byte) (uint16, []byte) {
+		if cmd == SEARCHD_COMMAND_SEARCH {
+			atomic.StoreUint32(seen, uint32(ver))
+			if ver > daemon {
+				msg := fmt.Sprintf("client version is higher than daemon version (client is v.%d.%d, daemon is v.%d.%d)",
+					ver>>8, ver&0xff, daemon>>8, daemon&0xff)
+				return SEARCHD_ERROR, (&packet{}).str(msg).Bytes()
+			}
+		}
+		return replyOK(cmd, ver, body)
+	}
+}
+
+func TestSearchLayout(t *testing.T) {
+	tests := map[int]uint16{
+		0x112: 0,
+		0x113: 0x113,
+		0x115: 0x113,
+		0x116: 0x116,
+		0x118: 0x117,
+		0x11B: 0x119,
+		0x11D: 0x119,
+		0x11E: 0x11E,
+		0x123: 0x11E,
+	}
+	for ver, want := range tests {
+		if got := searchLayout(ver); got != want {
+			t.Errorf("searchLayout(0x%x) = 0x%x, want 0x%x", ver, got, want)
+		}
+	}
+
+	c := NewClient()
+	if err := c.SetProtocolVersion(0x11C); err != nil || c.GetProtocolVersion() != 0x119 {
+		t.Fatalf("SetProtocolVersion(0x11C): %v, using 0x%x", err, c.GetProtocolVersion())
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	var seen uint32
+	_, path := newUnixSearchd(t, versionedReply(0x11B, &seen))
+
+	c := NewClient()
+	c.SetServer(path, 0)
+	if _, err := c.Search(NewSearchRequest("test", "")); err != nil {
+		t.Fatal(err)
+	}
+	// 0x11B 没有对应的布局，请求头和请求体都按 0x119 发送
+	if c.GetProtocolVersion() != 0x119 || atomic.LoadUint32(&seen) != 0x119 {
+		t.Fatalf("negotiated 0x%x, sent 0x%x", c.GetProtocolVersion(), atomic.LoadUint32(&seen))
+	}
+}
+
+func TestNegotiateAfterSetServer(t *testing.T) {
+	var newSeen, oldSeen uint32
+	_, newPath := newUnixSearchd(t, versionedReply(0x11E, &newSeen))
+	_, oldPath := newUnixSearchd(t, versionedReply(0x116, &oldSeen))
+
+	c := NewClient()
+	for i, path := range []string{newPath, oldPath, newPath} {
+		c.SetServer(path, 0)
+		if _, err := c.Search(NewSearchRequest("test", "")); err != nil {
+			t.Fatalf("server %d: %v", i, err)
+		}
+	}
+	if atomic.LoadUint32(&oldSeen) != 0x116 || atomic.LoadUint32(&newSeen) != 0x11E {
+		t.Fatalf("sent 0x%x to the 0.9.9 node and 0x%x to the 2.2 node", oldSeen, newSeen)
+	}
+
+	// 用 SetProtocolVersion 固定的版本在切换服务端时保留
+	c.SetProtocolVersion(0x116)
+	c.SetServer(newPath, 0)
+	if c.GetProtocolVersion() != 0x116 {
+		t.Fatalf("pinned version reset to 0x%x", c.GetProtocolVersion())
+	}
+}
+
+// 取消发生在 watchConn 启动和设置读写 deadline 之间时，过期的 deadline 曾经被清掉，请求一直等到 searchd 回复
+func TestCancelNotLost(t *testing.T) {
+	never := make(chan struct{})
+	_, path := newUnixSearchd(t, func(cmd uint16, ver uint16, body []byte) (uint16, []byte) {
+		<-never
+		return replyOK(cmd, ver, body)
+	})
+	t.Cleanup(func() { close(never) })
+
+	c := newPooledClient(t, path, 0, 2)
+	defer c.Close()
+
+	for i := 0; i < 500; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		if i%2 == 0 {
+			cancel()
+		} else {
+			go cancel()
+		}
+
+		errc := make(chan error, 1)
+		go func() {
+			_, err := c.SearchContext(ctx, NewSearchRequest("test", ""))
+			errc <- err
+		}()
+
+		select {
+		case err := <-errc:
+			if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+				t.Fatalf("iteration %d: %v", i, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("iteration %d: cancelled search did not return", i)
+		}
+	}
+}
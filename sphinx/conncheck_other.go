@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package sphinx
+
+import "net"
+
+// alive 在这些平台上不做检查，已被服务端关闭的空闲连接由 request 在 ErrConnLost 时重连一次处理
+func alive(conn net.Conn) bool {
+	return true
+}
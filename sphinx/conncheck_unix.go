@@ -0,0 +1,34 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package sphinx
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// alive 在取出空闲连接时检查它是否还能用。searchd 不会主动发送数据，
+// 非阻塞地 peek 一个字节：没有数据可读说明连接正常，读到 EOF、数据或其他错误说明服务端已经关闭了连接
+func alive(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return true
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	var rerr error
+	buf := make([]byte, 1)
+	err = rc.Read(func(fd uintptr) bool {
+		_, _, rerr = syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		return true
+	})
+	if err != nil {
+		return false
+	}
+
+	return errors.Is(rerr, syscall.EAGAIN) || errors.Is(rerr, syscall.EWOULDBLOCK)
+}
@@ -0,0 +1,266 @@
+package sphinx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrMalformedResponse = errors.New("malformed searchd response")
+
+// reader 对 searchd 响应做带边界检查的顺序读取。
+// 第一次越界时记录带偏移量的 ErrMalformedResponse，之后的读取都返回零值，调用方在合适的位置检查 err 即可
+type reader struct {
+	buf []byte
+	p   int
+	err error
+}
+
+func newReader(buf []byte) *reader {
+	return &reader{buf: buf}
+}
+
+func (r *reader) remaining() int {
+	return len(r.buf) - r.p
+}
+
+// need 检查还剩 n 个字节可读
+func (r *reader) need(n int, what string) bool {
+	if r.err != nil {
+		return false
+	}
+	if n < 0 || n > r.remaining() {
+		r.err = fmt.Errorf("%w: %s needs %d bytes at offset %d, %d left", ErrMalformedResponse, what, n, r.p, r.remaining())
+		return false
+	}
+	return true
+}
+
+func (r *reader) uint32(what string) uint32 {
+	if !r.need(4, what) {
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf[r.p : r.p+4])
+	r.p += 4
+	return v
+}
+
+func (r *reader) uint64(what string) uint64 {
+	if !r.need(8, what) {
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.buf[r.p : r.p+8])
+	r.p += 8
+	return v
+}
+
+func (r *reader) bytes(n int, what string) []byte {
+	if !r.need(n, what) {
+		return nil
+	}
+	v := r.buf[r.p : r.p+n]
+	r.p += n
+	return v
+}
+
+// string 读取 4 字节长度前缀的字符串
+func (r *reader) string(what string) string {
+	l := r.uint32(what)
+	return string(r.bytes(int(l), what))
+}
+
+// count 读取一个元素个数，并按每个元素至少 size 字节检查剩余长度，避免按伪造的个数分配内存或空转。
+// 比较在 uint64 上进行，32 位平台上 >= 2^31 的个数不会变成负数绕过检查
+func (r *reader) count(size int, what string) int {
+	n := r.uint32(what)
+	if r.err == nil && uint64(n) > uint64(r.remaining()/size) {
+		r.err = fmt.Errorf("%w: %s count %d exceeds remaining %d bytes at offset %d", ErrMalformedResponse, what, n, r.remaining(), r.p)
+		return 0
+	}
+	return int(n)
+}
+
+// parseSearchResponse 解析 search 命令的响应，reqs 为请求中的查询，决定结果条数和 Matches 的 key
+func parseSearchResponse(response []byte, reqs []SearchRequest, warning string) ([]Result, error) {
+
+	r := newReader(response)
+	results := []Result{}
+
+	for ires := range reqs {
+		result := Result{index: ires, Warning: warning, Matches: map[interface{}]Matches{}, Words: map[string]Words{}}
+		// extract status
+		status := r.uint32("status")
+		result.Status = int(status)
+
+		if status != SEARCHD_OK {
+			message := r.string("status message")
+
+			if status == SEARCHD_WARNING {
+				result.Warning = message
+			} else {
+				if r.err != nil {
+					return nil, fmt.Errorf("query %d: %w", ires, r.err)
+				}
+				result.Error = message
+				results = append(results, result)
+				continue
+			}
+		}
+
+		//read schema
+		fields := []string{}
+		attrs := map[string]uint32{}
+
+		//fields
+		for nfields := r.count(4, "fields"); nfields > 0 && r.err == nil; nfields-- {
+			fields = append(fields, r.string("field name"))
+		}
+		result.Fields = fields
+
+		//attrs
+		attrsOrder := []string{}
+		for nattrs := r.count(8, "attrs"); nattrs > 0 && r.err == nil; nattrs-- {
+			attr := r.string("attr name")
+			t := r.uint32("attr type")
+			if r.err == nil && attrDecoderFor(t) == nil {
+				r.err = fmt.Errorf("%w: unknown attr type %d for %s at offset %d", ErrMalformedResponse, t, attr, r.p)
+			}
+
+			attrsOrder = append(attrsOrder, attr)
+			attrs[attr] = t
+		}
+		result.Attrs = attrs
+
+		// read match count matches
+		count := r.count(8, "matches")
+		id64 := r.uint32("id64 marker")
+
+		//matches
+		for idx := 0; idx < count && r.err == nil; idx++ {
+			var doc uint64
+			if id64 == 1 {
+				doc = r.uint64("document id")
+			} else {
+				doc = uint64(r.uint32("document id"))
+			}
+			weight := r.uint32("weight")
+
+			attrvals := map[string]interface{}{}
+			for _, attr := range attrsOrder {
+				attrvals[attr] = attrDecoderFor(attrs[attr])(r)
+			}
+
+			geodist, _ := attrvals["@geodist"].(float32)
+
+			// create match entry
+			if reqs[ires].arrayresult {
+				result.Matches[idx] = Matches{Id: doc, Weight: weight, GeoDist: geodist, Attrs: attrvals}
+			} else {
+				result.Matches[doc] = Matches{Weight: weight, GeoDist: geodist, Attrs: attrvals}
+			}
+		}
+
+		result.Total = r.uint32("total")
+		result.TotalFound = r.uint32("total_found")
+		result.Time = float32(r.uint32("time")) / 1000
+
+		for words := r.count(12, "words"); words > 0 && r.err == nil; words-- {
+			word := r.string("word")
+			result.Words[word] = Words{
+				Docs: r.uint32("word docs"),
+				Hits: r.uint32("word hits"),
+			}
+		}
+
+		if r.err != nil {
+			return nil, fmt.Errorf("query %d: %w", ires, r.err)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// attrDecoder 从 r 中解出一个属性值
+type attrDecoder func(r *reader) interface{}
+
+// attrDecoders 按属性类型解码，每种类型只消耗它在协议中的字节宽度
+var attrDecoders = map[uint32]attrDecoder{
+	SPH_ATTR_INTEGER:   decodeUint32,
+	SPH_ATTR_TIMESTAMP: decodeUint32,
+	SPH_ATTR_ORDINAL:   decodeUint32,
+	SPH_ATTR_BOOL:      decodeUint32,
+	SPH_ATTR_FLOAT:     decodeFloat,
+	SPH_ATTR_BIGINT:    decodeInt64,
+	SPH_ATTR_STRING:    decodeString,
+	SPH_ATTR_MULTI:     decodeMulti,
+	SPH_ATTR_MULTI64:   decodeMulti64,
+	SPH_ATTR_FACTORS:   decodeFactors,
+}
+
+// attrDecoderFor 返回类型 tp 的解码函数，未知类型返回 nil。
+// 猜一个宽度去读只会让之后的数据流错位，所以由调用方报告 ErrMalformedResponse
+func attrDecoderFor(tp uint32) attrDecoder {
+	if d, ok := attrDecoders[tp]; ok {
+		return d
+	}
+	if (tp & SPH_ATTR_MULTI) > 0 {
+		return attrDecoders[SPH_ATTR_MULTI]
+	}
+	return nil
+}
+
+func decodeUint32(r *reader) interface{} {
+	return r.uint32("attr value")
+}
+
+func decodeFloat(r *reader) interface{} {
+	return math.Float32frombits(r.uint32("float attr value"))
+}
+
+func decodeInt64(r *reader) interface{} {
+	return int64(r.uint64("bigint attr value"))
+}
+
+func decodeString(r *reader) interface{} {
+	return r.string("string attr value")
+}
+
+// decodeFactors PACKEDFACTORS() 的数据，长度前缀包含它自己的 4 个字节，0 表示没有数据
+func decodeFactors(r *reader) interface{} {
+	n := r.uint32("factors length")
+	if n == 0 {
+		return []byte{}
+	}
+	if n < 4 {
+		r.err = fmt.Errorf("%w: factors length %d at offset %d", ErrMalformedResponse, n, r.p)
+		return []byte{}
+	}
+	return append([]byte(nil), r.bytes(int(n-4), "factors")...)
+}
+
+func decodeMulti(r *reader) interface{} {
+	n := r.count(4, "mva")
+
+	vals := make([]uint32, 0, n)
+	for ; n > 0 && r.err == nil; n-- {
+		vals = append(vals, r.uint32("mva value"))
+	}
+	return vals
+}
+
+// decodeMulti64 64 位 mva 的数量按 32 位字计算，每个值占两个字
+func decodeMulti64(r *reader) interface{} {
+	n := r.count(4, "mva64")
+	if n%2 != 0 && r.err == nil {
+		r.err = fmt.Errorf("%w: mva64 word count %d is odd at offset %d", ErrMalformedResponse, n, r.p)
+	}
+
+	vals := make([]uint64, 0, n/2)
+	for ; n > 1 && r.err == nil; n -= 2 {
+		vals = append(vals, r.uint64("mva64 value"))
+	}
+	return vals
+}
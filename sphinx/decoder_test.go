@@ -0,0 +1,326 @@
+package sphinx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// packet 按 searchd 的线上格式拼装响应体
+type packet struct {
+	bytes.Buffer
+}
+
+func (p *packet) u32(vals ...uint32) *packet {
+	for _, v := range vals {
+		binary.Write(p, binary.BigEndian, v)
+	}
+	return p
+}
+
+func (p *packet) u64(vals ...uint64) *packet {
+	for _, v := range vals {
+		binary.Write(p, binary.BigEndian, v)
+	}
+	return p
+}
+
+func (p *packet) str(vals ...string) *packet {
+	for _, v := range vals {
+		p.u32(uint32(len(v)))
+		p.WriteString(v)
+	}
+	return p
+}
+
+// searchReply 一条 2.2 search 响应中的单个结果集，
+// 覆盖所有属性类型，外加 SetGeoAnchor 产生的 @geodist 和 select 表达式属性
+func searchReply(p *packet) *packet {
+	p.u32(SEARCHD_OK)
+	p.u32(2).str("title", "content")
+
+	p.u32(9)
+	p.str("group_id").u32(SPH_ATTR_INTEGER)
+	p.str("date_added").u32(SPH_ATTR_TIMESTAMP)
+	p.str("price").u32(SPH_ATTR_FLOAT)
+	p.str("views").u32(SPH_ATTR_BIGINT)
+	p.str("author").u32(SPH_ATTR_STRING)
+	p.str("tags").u32(SPH_ATTR_MULTI)
+	p.str("tags64").u32(SPH_ATTR_MULTI64)
+	p.str("@geodist").u32(SPH_ATTR_FLOAT)
+	p.str("discounted").u32(SPH_ATTR_FLOAT)
+
+	p.u32(2, 1) // matches, id64
+	for i, id := range []uint64{1001, 1 << 40} {
+		p.u64(id).u32(1500 + uint32(i))
+		p.u32(7, 1199145600)
+		p.u32(math.Float32bits(9.5))
+		p.u64(uint64(1) << 33)
+		p.str("alice")
+		p.u32(3, 1, 2, 3)
+		p.u32(4).u64(uint64(1)<<36, 5)
+		p.u32(math.Float32bits(1234.5))
+		p.u32(math.Float32bits(8.55))
+	}
+
+	p.u32(2, 57, 12) // total, total_found, time
+	p.u32(2)
+	p.str("test").u32(40, 57)
+	p.str("doc").u32(12, 15)
+	return p
+}
+
+func searchSeeds() [][]byte {
+	ok := searchReply(&packet{}).Bytes()
+
+	warning := &packet{}
+	warning.u32(SEARCHD_WARNING).str("quorum threshold too high (words=2, thresh=3); replacing quorum operator with AND operator")
+	warning.u32(0, 0, 0, 1, 0, 0, 0, 0)
+
+	failed := &packet{}
+	failed.u32(SEARCHD_ERROR).str("index test1: no such index")
+
+	batch := searchReply(&packet{})
+	batch.u32(SEARCHD_ERROR).str("index test1: query error: no field 'x' found in schema")
+
+	id32 := &packet{}
+	id32.u32(SEARCHD_OK, 1).str("title")
+	id32.u32(1).str("group_id").u32(SPH_ATTR_INTEGER)
+	id32.u32(1, 0).u32(42, 1, 5)
+	id32.u32(1, 1, 0, 0)
+
+	return [][]byte{ok, warning.Bytes(), failed.Bytes(), batch.Bytes(), id32.Bytes(), factorsReply(&packet{}, 4+6).Bytes()}
+}
+
+// factorsReply SPH_RANK_EXPORT 下 select PACKEDFACTORS() 的结果集，
+// 第一条匹配带 6 字节因子数据，长度前缀为 length，第二条匹配没有因子数据
+func factorsReply(p *packet, length uint32) *packet {
+	p.u32(SEARCHD_OK)
+	p.u32(1).str("title")
+	p.u32(2)
+	p.str("packedfactors()").u32(SPH_ATTR_FACTORS)
+	p.str("group_id").u32(SPH_ATTR_INTEGER)
+
+	p.u32(2, 1)
+	p.u64(1).u32(1500)
+	p.u32(length).Write([]byte{1, 2, 3, 4, 5, 6})
+	p.u32(7)
+	p.u64(2).u32(1400)
+	p.u32(0)
+	p.u32(8)
+
+	p.u32(2, 2, 0, 0)
+	return p
+}
+
+func statusSeed() []byte {
+	p := &packet{}
+	p.u32(5, 2)
+	p.str("uptime", "3600")
+	p.str("connections", "1234")
+	p.str("queries", "987")
+	p.str("avg_query_wall", "0.015")
+	p.str("command_search", "980")
+	return p.Bytes()
+}
+
+func keywordsSeed(hits bool) []byte {
+	p := &packet{}
+	p.u32(2)
+	p.str("running", "run")
+	if hits {
+		p.u32(12, 30)
+	}
+	p.str("dogs", "dog")
+	if hits {
+		p.u32(5, 6)
+	}
+	return p.Bytes()
+}
+
+func queries(n int, arrayresult bool) []SearchRequest {
+	reqs := make([]SearchRequest, n)
+	for i := range reqs {
+		reqs[i] = NewSearchRequest("test", "")
+		reqs[i].SetArrayResult(arrayresult)
+	}
+	return reqs
+}
+
+func TestParseSearchResponse(t *testing.T) {
+	res, err := parseSearchResponse(searchSeeds()[0], queries(1, true), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := res[0]
+	if r.Status != SEARCHD_OK || len(r.Matches) != 2 || r.Total != 2 || r.TotalFound != 57 || r.Time != 0.012 {
+		t.Fatalf("unexpected result header %+v", r)
+	}
+	if !reflect.DeepEqual(r.Fields, []string{"title", "content"}) {
+		t.Fatalf("fields %v", r.Fields)
+	}
+	if r.Words["test"] != (Words{Docs: 40, Hits: 57}) {
+		t.Fatalf("words %v", r.Words)
+	}
+
+	m := r.Matches[1]
+	if m.Id != 1<<40 || m.Weight != 1501 || m.GeoDist != 1234.5 {
+		t.Fatalf("match %+v", m)
+	}
+	if m.Int("group_id") != 7 || m.Int("views") != 1<<33 || m.Float("price") != 9.5 || m.String("author") != "alice" {
+		t.Fatalf("attrs %v", m.Attrs)
+	}
+	if !reflect.DeepEqual(m.MVA("tags"), []uint64{1, 2, 3}) || !reflect.DeepEqual(m.MVA("tags64"), []uint64{1 << 36, 5}) {
+		t.Fatalf("mva %v %v", m.MVA("tags"), m.MVA("tags64"))
+	}
+}
+
+func TestParseSearchResponseStatus(t *testing.T) {
+	seeds := searchSeeds()
+
+	res, err := parseSearchResponse(seeds[1], queries(1, false), "")
+	if err != nil || res[0].Status != SEARCHD_WARNING || res[0].Warning == "" || res[0].Err() != nil {
+		t.Fatalf("warning: %+v %v", res, err)
+	}
+
+	res, err = parseSearchResponse(seeds[3], queries(2, false), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Err() != nil || len(res[0].Matches) != 2 {
+		t.Fatalf("first query %+v", res[0])
+	}
+	var serr *SearchdError
+	if !errors.As(res[1].Err(), &serr) || serr.QueryIndex != 1 || serr.Status != SEARCHD_ERROR {
+		t.Fatalf("second query %v", res[1].Err())
+	}
+}
+
+func TestParseSearchResponseTruncated(t *testing.T) {
+	seed := searchSeeds()[0]
+	for n := 0; n < len(seed); n++ {
+		if _, err := parseSearchResponse(seed[:n], queries(1, false), ""); !errors.Is(err, ErrMalformedResponse) {
+			t.Fatalf("truncated to %d bytes: %v", n, err)
+		}
+	}
+}
+
+func TestParseSearchResponseFactors(t *testing.T) {
+	res, err := parseSearchResponse(factorsReply(&packet{}, 4+6).Bytes(), queries(1, true), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := res[0].Matches[0]
+	if !reflect.DeepEqual(m.Factors("packedfactors()"), []byte{1, 2, 3, 4, 5, 6}) || m.Int("group_id") != 7 {
+		t.Fatalf("match %+v", m)
+	}
+	m = res[0].Matches[1]
+	if len(m.Factors("packedfactors()")) != 0 || m.Int("group_id") != 8 {
+		t.Fatalf("match without factors %+v", m)
+	}
+
+	// 长度前缀包含自身的 4 个字节，小于 4 的长度是坏数据
+	bad := factorsReply(&packet{}, 3).Bytes()
+	if _, err := parseSearchResponse(bad, queries(1, true), ""); !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("factors length 3: %v", err)
+	}
+}
+
+// 未知的属性类型不能猜宽度去读，否则之后的数据全部错位
+func TestParseSearchResponseUnknownAttr(t *testing.T) {
+	p := &packet{}
+	p.u32(SEARCHD_OK, 0)
+	p.u32(1).str("vec").u32(12)
+	p.u32(1, 1).u64(1).u32(1).u32(0, 0)
+	p.u32(1, 1, 0, 0)
+
+	_, err := parseSearchResponse(p.Bytes(), queries(1, false), "")
+	if !errors.Is(err, ErrMalformedResponse) || !strings.Contains(err.Error(), "unknown attr type 12") {
+		t.Fatalf("unknown attr type: %v", err)
+	}
+}
+
+// 32 位平台上 >= 2^31 的个数曾经变成负数绕过长度检查，随后在 make 中 panic
+func TestDecodeHugeCount(t *testing.T) {
+	huge := []byte{0x80, 0, 0, 0, 0, 0, 0, 0}
+
+	for name, decode := range map[string]attrDecoder{"mva": decodeMulti, "mva64": decodeMulti64} {
+		r := newReader(huge)
+		decode(r)
+		if !errors.Is(r.err, ErrMalformedResponse) {
+			t.Fatalf("%s: %v", name, r.err)
+		}
+	}
+
+	if _, err := parseStatusResponse(append(huge, 0, 0, 0, 2)); !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("status: %v", err)
+	}
+}
+
+func TestParseStatusResponse(t *testing.T) {
+	st, err := parseStatusResponse(statusSeed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Uptime != 3600 || st.Connections != 1234 || st.AvgQueryWall != 0.015 || st.Raw["command_search"] != "980" {
+		t.Fatalf("status %+v", st)
+	}
+}
+
+func TestParseKeywordsResponse(t *testing.T) {
+	kws, err := parseKeywordsResponse(keywordsSeed(true), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Keyword{{"running", "run", 12, 30}, {"dogs", "dog", 5, 6}}
+	if !reflect.DeepEqual(kws, want) {
+		t.Fatalf("keywords %+v", kws)
+	}
+}
+
+func FuzzParseSearchResponse(f *testing.F) {
+	for _, seed := range searchSeeds() {
+		f.Add(seed, uint8(1), false)
+		f.Add(seed, uint8(2), true)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, nreqs uint8, arrayresult bool) {
+		res, err := parseSearchResponse(data, queries(int(nreqs%4)+1, arrayresult), "")
+		if err == nil && len(res) != int(nreqs%4)+1 {
+			t.Fatalf("%d results for %d queries", len(res), nreqs%4+1)
+		}
+		if err != nil && !errors.Is(err, ErrMalformedResponse) {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		decodeMulti(newReader(data))
+		decodeMulti64(newReader(data))
+	})
+}
+
+func FuzzParseStatusResponse(f *testing.F) {
+	f.Add(statusSeed())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := parseStatusResponse(data); err != nil && !errors.Is(err, ErrMalformedResponse) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
+
+func FuzzParseKeywordsResponse(f *testing.F) {
+	f.Add(keywordsSeed(true), true)
+	f.Add(keywordsSeed(false), false)
+
+	f.Fuzz(func(t *testing.T, data []byte, hits bool) {
+		if _, err := parseKeywordsResponse(data, hits); err != nil && !errors.Is(err, ErrMalformedResponse) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
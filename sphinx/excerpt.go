@@ -0,0 +1,179 @@
+package sphinx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+)
+
+// ExcerptOptions BuildExcerpts 的可选参数，字段含义与 php 客户端 $opts 一一对应。
+// 与 php 客户端对未设置的 key 填默认值一样，字符串字段为空、StartPassageId 为 0 时使用 DefaultExcerptOptions 中的值；
+// Limit 和 Around 为 0 在 searchd 中有意义（不限制长度、不保留上下文），不会被替换
+type ExcerptOptions struct {
+	BeforeMatch        string
+	AfterMatch         string
+	ChunkSeparator     string
+	Limit              int
+	Around             int
+	LimitPassages      int
+	LimitWords         int
+	StartPassageId     int
+	ExactPhrase        bool
+	SinglePassage      bool
+	UseBoundaries      bool
+	WeightOrder        bool
+	QueryMode          bool
+	ForceAllWords      bool
+	LoadFiles          bool
+	LoadFilesScattered bool
+	AllowEmpty         bool
+	EmitZones          bool
+	HtmlStripMode      string
+	PassageBoundary    string
+}
+
+// DefaultExcerptOptions 返回与 php 客户端一致的默认参数
+func DefaultExcerptOptions() ExcerptOptions {
+	return ExcerptOptions{
+		BeforeMatch:     "<b>",
+		AfterMatch:      "</b>",
+		ChunkSeparator:  " ... ",
+		Limit:           256,
+		Around:          5,
+		StartPassageId:  1,
+		HtmlStripMode:   "index",
+		PassageBoundary: "none",
+	}
+}
+
+// withDefaults 用 DefaultExcerptOptions 填充未设置的字段
+func (o ExcerptOptions) withDefaults() ExcerptOptions {
+	def := DefaultExcerptOptions()
+	if o.BeforeMatch == "" {
+		o.BeforeMatch = def.BeforeMatch
+	}
+	if o.AfterMatch == "" {
+		o.AfterMatch = def.AfterMatch
+	}
+	if o.ChunkSeparator == "" {
+		o.ChunkSeparator = def.ChunkSeparator
+	}
+	if o.StartPassageId == 0 {
+		o.StartPassageId = def.StartPassageId
+	}
+	if o.HtmlStripMode == "" {
+		o.HtmlStripMode = def.HtmlStripMode
+	}
+	if o.PassageBoundary == "" {
+		o.PassageBoundary = def.PassageBoundary
+	}
+	return o
+}
+
+func (o ExcerptOptions) flags() int32 {
+	flags := int32(1) // remove spaces
+	if o.ExactPhrase {
+		flags |= 2
+	}
+	if o.SinglePassage {
+		flags |= 4
+	}
+	if o.UseBoundaries {
+		flags |= 8
+	}
+	if o.WeightOrder {
+		flags |= 16
+	}
+	if o.QueryMode {
+		flags |= 32
+	}
+	if o.ForceAllWords {
+		flags |= 64
+	}
+	if o.LoadFiles {
+		flags |= 128
+	}
+	if o.AllowEmpty {
+		flags |= 256
+	}
+	if o.EmitZones {
+		flags |= 512
+	}
+	if o.LoadFilesScattered {
+		flags |= 1024
+	}
+	return flags
+}
+
+// BuildExcerpts 按 index 的分词设置对 docs 中每个文档生成高亮片段，返回结果与 docs 一一对应
+func (c *Client) BuildExcerpts(docs []string, index string, words string, opts ExcerptOptions) ([]string, error) {
+	return c.BuildExcerptsContext(context.Background(), docs, index, words, opts)
+}
+
+// BuildExcerptsContext 同 BuildExcerpts，ctx 取消时中断连接和读写
+func (c *Client) BuildExcerptsContext(ctx context.Context, docs []string, index string, words string, opts ExcerptOptions) ([]string, error) {
+
+	opts = opts.withDefaults()
+
+	buff := bytes.NewBuffer([]byte{})
+
+	//$req = pack ( "NN", 0, $flags ); // mode=0, flags=$flags
+	binary.Write(buff, binary.BigEndian, int32(0))
+	binary.Write(buff, binary.BigEndian, opts.flags())
+
+	//$req .= pack ( "N", strlen($index) ) . $index; // req index
+	binary.Write(buff, binary.BigEndian, int32(len(index)))
+	buff.Write([]byte(index))
+
+	//$req .= pack ( "N", strlen($words) ) . $words; // req words
+	binary.Write(buff, binary.BigEndian, int32(len(words)))
+	buff.Write([]byte(words))
+
+	// options
+	binary.Write(buff, binary.BigEndian, int32(len(opts.BeforeMatch)))
+	buff.Write([]byte(opts.BeforeMatch))
+	binary.Write(buff, binary.BigEndian, int32(len(opts.AfterMatch)))
+	buff.Write([]byte(opts.AfterMatch))
+	binary.Write(buff, binary.BigEndian, int32(len(opts.ChunkSeparator)))
+	buff.Write([]byte(opts.ChunkSeparator))
+
+	//$req .= pack ( "NN", (int)$opts["limit"], (int)$opts["around"] );
+	binary.Write(buff, binary.BigEndian, int32(opts.Limit))
+	binary.Write(buff, binary.BigEndian, int32(opts.Around))
+
+	//$req .= pack ( "NNN", (int)$opts["limit_passages"], (int)$opts["limit_words"], (int)$opts["start_passage_id"] );
+	binary.Write(buff, binary.BigEndian, int32(opts.LimitPassages))
+	binary.Write(buff, binary.BigEndian, int32(opts.LimitWords))
+	binary.Write(buff, binary.BigEndian, int32(opts.StartPassageId))
+
+	binary.Write(buff, binary.BigEndian, int32(len(opts.HtmlStripMode)))
+	buff.Write([]byte(opts.HtmlStripMode))
+	binary.Write(buff, binary.BigEndian, int32(len(opts.PassageBoundary)))
+	buff.Write([]byte(opts.PassageBoundary))
+
+	// documents
+	binary.Write(buff, binary.BigEndian, int32(len(docs)))
+	for _, doc := range docs {
+		binary.Write(buff, binary.BigEndian, int32(len(doc)))
+		buff.Write([]byte(doc))
+	}
+
+	response, _, err := c.request(ctx, SEARCHD_COMMAND_EXCERPT, VER_COMMAND_EXCERPT, buff.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	//parse response
+	r := newReader(response)
+	res := make([]string, 0, len(docs))
+
+	for range docs {
+		res = append(res, r.string("excerpt"))
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,68 @@
+package sphinx
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBuildExcerptsEncoding(t *testing.T) {
+	c, last := captureSearchd(t, func(body []byte) []byte {
+		return (&packet{}).str("<em>hello</em> world").Bytes()
+	})
+
+	docs := []string{"hello world"}
+	res, err := c.BuildExcerpts(docs, "test", "hello", ExcerptOptions{BeforeMatch: "<em>", ExactPhrase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, []string{"<em>hello</em> world"}) {
+		t.Fatalf("excerpts %q", res)
+	}
+
+	// 未设置的字符串字段和 start_passage_id 按 php 客户端的默认值发送，limit 和 around 原样发送
+	want := &packet{}
+	want.u32(0, 1|2)
+	want.str("test", "hello")
+	want.str("<em>", "</b>", " ... ")
+	want.u32(0, 0)
+	want.u32(0, 0, 1)
+	want.str("index", "none")
+	want.u32(1).str("hello world")
+
+	cmd, ver, body := last()
+	if cmd != SEARCHD_COMMAND_EXCERPT || ver != VER_COMMAND_EXCERPT {
+		t.Fatalf("command %d version 0x%x", cmd, ver)
+	}
+	if !bytes.Equal(body, want.Bytes()) {
+		t.Fatalf("body\n%x\nwant\n%x", body, want.Bytes())
+	}
+
+	if _, err := c.BuildExcerpts(docs, "test", "hello", DefaultExcerptOptions()); err != nil {
+		t.Fatal(err)
+	}
+	_, _, body = last()
+	r := newReader(body)
+	r.bytes(4+4+4+len("test")+4+len("hello")+4+len("<b>")+4+len("</b>")+4+len(" ... "), "prefix")
+	if limit, around := r.uint32("limit"), r.uint32("around"); limit != 256 || around != 5 || r.err != nil {
+		t.Fatalf("limit %d around %d: %v", limit, around, r.err)
+	}
+}
+
+func TestExcerptOptionsWithDefaults(t *testing.T) {
+	if got := (ExcerptOptions{}).withDefaults(); got != (ExcerptOptions{
+		BeforeMatch:     "<b>",
+		AfterMatch:      "</b>",
+		ChunkSeparator:  " ... ",
+		StartPassageId:  1,
+		HtmlStripMode:   "index",
+		PassageBoundary: "none",
+	}) {
+		t.Fatalf("zero options %+v", got)
+	}
+
+	set := ExcerptOptions{BeforeMatch: "[", AfterMatch: "]", ChunkSeparator: "|", StartPassageId: 3, HtmlStripMode: "strip", PassageBoundary: "sentence"}
+	if got := set.withDefaults(); got != set {
+		t.Fatalf("set options replaced %+v", got)
+	}
+}
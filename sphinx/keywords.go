@@ -0,0 +1,75 @@
+package sphinx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+)
+
+// Keyword BuildKeywords 返回的单个关键词
+type Keyword struct {
+	Tokenized  string
+	Normalized string
+	Docs       uint32
+	Hits       uint32
+}
+
+// BuildKeywords 按 index 的分词设置切分 query，hits 为 true 时同时返回每个词的文档数和命中数
+func (c *Client) BuildKeywords(query string, index string, hits bool) ([]Keyword, error) {
+	return c.BuildKeywordsContext(context.Background(), query, index, hits)
+}
+
+// BuildKeywordsContext 同 BuildKeywords，ctx 取消时中断连接和读写
+func (c *Client) BuildKeywordsContext(ctx context.Context, query string, index string, hits bool) ([]Keyword, error) {
+
+	buff := bytes.NewBuffer([]byte{})
+
+	//$req = pack ( "N", strlen($query) ) . $query; // req query
+	binary.Write(buff, binary.BigEndian, int32(len(query)))
+	buff.Write([]byte(query))
+
+	//$req .= pack ( "N", strlen($index) ) . $index; // req index
+	binary.Write(buff, binary.BigEndian, int32(len(index)))
+	buff.Write([]byte(index))
+
+	//$req .= pack ( "N", (int)$hits );
+	if hits {
+		binary.Write(buff, binary.BigEndian, int32(1))
+	} else {
+		binary.Write(buff, binary.BigEndian, int32(0))
+	}
+
+	response, _, err := c.request(ctx, SEARCHD_COMMAND_KEYWORDS, VER_COMMAND_KEYWORDS, buff.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return parseKeywordsResponse(response, hits)
+}
+
+// parseKeywordsResponse 解析 keywords 命令的响应，hits 与请求中的一致
+func parseKeywordsResponse(response []byte, hits bool) ([]Keyword, error) {
+
+	r := newReader(response)
+	res := []Keyword{}
+
+	for nwords := r.count(8, "keywords"); nwords > 0 && r.err == nil; nwords-- {
+		kw := Keyword{
+			Tokenized:  r.string("tokenized"),
+			Normalized: r.string("normalized"),
+		}
+
+		if hits {
+			kw.Docs = r.uint32("docs")
+			kw.Hits = r.uint32("hits")
+		}
+
+		res = append(res, kw)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,324 @@
+package sphinx
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// 默认保留的空闲连接数，与 database/sql 相同
+const defaultMaxIdleConns = 2
+
+// pool Client 的连接池状态，由 Client.mu 保护。
+// 只有 Open 之后的持久连接会放回空闲列表，非持久模式下新建的连接同样计入打开连接数
+type pool struct {
+	maxOpen     int
+	maxIdle     int
+	maxIdleTime time.Duration
+	maxLifetime time.Duration
+
+	numOpen int
+	idle    []*searchdConn // 按归还顺序排列，末尾是最近归还的
+	// 等待连接的请求，收到 nil 表示分到了一个新建连接的名额
+	waiters []chan *searchdConn
+
+	waitCount         int64
+	waitDuration      time.Duration
+	maxIdleClosed     int64
+	maxIdleTimeClosed int64
+	maxLifetimeClosed int64
+}
+
+// PoolStats 连接池的统计信息，字段含义与 database/sql 的 DBStats 相同
+type PoolStats struct {
+	MaxOpenConnections int // 打开连接数的上限，0 表示不限制
+
+	OpenConnections int // 已打开的连接数，包括使用中和空闲的
+	InUse           int
+	Idle            int
+
+	WaitCount         int64         // 因达到上限而等待连接的次数
+	WaitDuration      time.Duration // 等待连接的总时间
+	MaxIdleClosed     int64         // 因超过 SetMaxIdleConns 而关闭的连接数
+	MaxIdleTimeClosed int64         // 因超过 SetConnMaxIdleTime 而关闭的连接数
+	MaxLifetimeClosed int64         // 因超过 SetConnMaxLifetime 而关闭的连接数
+}
+
+// SetMaxOpenConns 设置同时打开的连接数上限，达到上限时请求会等待其他请求归还连接，n <= 0 表示不限制。
+// 空闲连接数上限大于 n 时会被一起调低
+func (c *Client) SetMaxOpenConns(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	c.mu.Lock()
+	c.pool.maxOpen = n
+	var stale []*searchdConn
+	if n > 0 && c.pool.maxIdle > n {
+		c.pool.maxIdle = n
+		stale = c.trimIdleLocked()
+	}
+	c.grantLocked()
+	c.mu.Unlock()
+
+	closeConns(stale)
+}
+
+// SetMaxIdleConns 设置连接池中保留的空闲连接数上限，默认为 2，n <= 0 表示不保留空闲连接
+func (c *Client) SetMaxIdleConns(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	c.mu.Lock()
+	if c.pool.maxOpen > 0 && n > c.pool.maxOpen {
+		n = c.pool.maxOpen
+	}
+	c.pool.maxIdle = n
+	stale := c.trimIdleLocked()
+	c.mu.Unlock()
+
+	closeConns(stale)
+}
+
+// SetConnMaxIdleTime 设置连接在池中空闲的最长时间，超过后在下次取用连接时关闭，0 表示不限制
+func (c *Client) SetConnMaxIdleTime(d time.Duration) {
+	c.mu.Lock()
+	c.pool.maxIdleTime = d
+	stale := c.pruneLocked(time.Now(), c.cfg.endpoint())
+	c.mu.Unlock()
+
+	closeConns(stale)
+}
+
+// SetConnMaxLifetime 设置连接从建立起可以复用的最长时间，超过后不再放回连接池，0 表示不限制
+func (c *Client) SetConnMaxLifetime(d time.Duration) {
+	c.mu.Lock()
+	c.pool.maxLifetime = d
+	stale := c.pruneLocked(time.Now(), c.cfg.endpoint())
+	c.mu.Unlock()
+
+	closeConns(stale)
+}
+
+// Stats 返回连接池当前的统计信息
+func (c *Client) Stats() PoolStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return PoolStats{
+		MaxOpenConnections: c.pool.maxOpen,
+		OpenConnections:    c.pool.numOpen,
+		InUse:              c.pool.numOpen - len(c.pool.idle),
+		Idle:               len(c.pool.idle),
+		WaitCount:          c.pool.waitCount,
+		WaitDuration:       c.pool.waitDuration,
+		MaxIdleClosed:      c.pool.maxIdleClosed,
+		MaxIdleTimeClosed:  c.pool.maxIdleTimeClosed,
+		MaxLifetimeClosed:  c.pool.maxLifetimeClosed,
+	}
+}
+
+// acquire 取出最近归还的空闲连接，没有时新建一个。
+// 打开的连接数达到 SetMaxOpenConns 的上限时等待其他请求归还连接或 ctx 结束。
+// 空闲连接在取出时用 alive 检查，已经被服务端关闭的连接用同一个名额重新建立；
+// 检查之后才断开的连接由 request 在 ErrConnLost 时重连一次处理
+func (c *Client) acquire(ctx context.Context, cfg clientConfig) (*searchdConn, error) {
+	// ctx 已经结束时不取出空闲连接，免得在一个注定要中断的请求上弄乱连接的数据流
+	if ctx.Err() != nil {
+		return nil, waitError(ctx, "acquire a connection")
+	}
+
+	c.mu.Lock()
+	stale := c.pruneLocked(time.Now(), cfg.endpoint())
+
+	var conn *searchdConn
+	var wait chan *searchdConn
+	if n := len(c.pool.idle); n > 0 {
+		conn = c.pool.idle[n-1]
+		c.pool.idle[n-1] = nil
+		c.pool.idle = c.pool.idle[:n-1]
+	} else if c.pool.maxOpen > 0 && c.pool.numOpen >= c.pool.maxOpen {
+		wait = make(chan *searchdConn, 1)
+		c.pool.waiters = append(c.pool.waiters, wait)
+		c.pool.waitCount++
+	} else {
+		c.pool.numOpen++
+	}
+	persist := c.persist
+	c.mu.Unlock()
+
+	closeConns(stale)
+
+	if wait != nil {
+		var err error
+		if conn, persist, err = c.wait(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+
+	if conn != nil && alive(conn.Conn) {
+		conn.reused = true
+		return conn, nil
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	return c.dial(ctx, cfg, persist)
+}
+
+// wait 等待分到连接或新建连接的名额，ctx 结束时放弃等待
+func (c *Client) wait(ctx context.Context, ch chan *searchdConn) (*searchdConn, bool, error) {
+	start := time.Now()
+
+	select {
+	case conn := <-ch:
+		c.mu.Lock()
+		c.pool.waitDuration += time.Since(start)
+		persist := c.persist
+		c.mu.Unlock()
+		return conn, persist, nil
+
+	case <-ctx.Done():
+		c.mu.Lock()
+		c.pool.waitDuration += time.Since(start)
+		removed := false
+		for i, w := range c.pool.waiters {
+			if w == ch {
+				c.pool.waiters = append(c.pool.waiters[:i], c.pool.waiters[i+1:]...)
+				removed = true
+				break
+			}
+		}
+		c.mu.Unlock()
+
+		// 放弃之前已经分到了连接或名额，还给连接池
+		if !removed {
+			if conn := <-ch; conn != nil {
+				c.release(conn, true)
+			} else {
+				c.mu.Lock()
+				c.closedLocked()
+				c.mu.Unlock()
+			}
+		}
+
+		return nil, false, waitError(ctx, "wait for a free connection")
+	}
+}
+
+// waitError ctx 在取到连接之前结束时的错误，ctx 到期时为 ErrTimeout，否则为 ErrNoClient
+func waitError(ctx context.Context, op string) error {
+	kind := ErrNoClient
+	if ctx.Err() == context.DeadlineExceeded {
+		kind = ErrTimeout
+	}
+	return fmt.Errorf("%w: %s", kind, op)
+}
+
+// dial 用已经占到的名额新建连接，失败时归还名额
+func (c *Client) dial(ctx context.Context, cfg clientConfig, persist bool) (*searchdConn, error) {
+	conn, err := connect(ctx, cfg, persist)
+	if err != nil {
+		c.mu.Lock()
+		c.closedLocked()
+		c.mu.Unlock()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// release 归还连接。reuse 为 false、连接不是持久连接、服务端地址已经改变或超过最长存活时间时关闭它，
+// 否则优先交给等待中的请求，其次放回空闲列表
+func (c *Client) release(conn *searchdConn, reuse bool) {
+	now := time.Now()
+
+	c.mu.Lock()
+	reuse = reuse && conn.persistent && c.persist && conn.endpoint == c.cfg.endpoint()
+
+	if reuse && c.pool.maxLifetime > 0 && now.Sub(conn.created) >= c.pool.maxLifetime {
+		c.pool.maxLifetimeClosed++
+	} else if reuse && len(c.pool.waiters) > 0 {
+		ch := c.pool.waiters[0]
+		c.pool.waiters = c.pool.waiters[1:]
+		ch <- conn
+		c.mu.Unlock()
+		return
+	} else if reuse && len(c.pool.idle) < c.pool.maxIdle {
+		conn.idleSince = now
+		c.pool.idle = append(c.pool.idle, conn)
+		c.mu.Unlock()
+		return
+	} else if reuse {
+		c.pool.maxIdleClosed++
+	}
+
+	c.closedLocked()
+	c.mu.Unlock()
+
+	conn.Close()
+}
+
+// closedLocked 记录关闭了一个连接，空出的名额交给等待中的请求
+func (c *Client) closedLocked() {
+	c.pool.numOpen--
+	c.grantLocked()
+}
+
+// grantLocked 在没有达到上限时，给等待中的请求分配新建连接的名额
+func (c *Client) grantLocked() {
+	for len(c.pool.waiters) > 0 && (c.pool.maxOpen == 0 || c.pool.numOpen < c.pool.maxOpen) {
+		ch := c.pool.waiters[0]
+		c.pool.waiters = c.pool.waiters[1:]
+		c.pool.numOpen++
+		ch <- nil
+	}
+}
+
+// pruneLocked 从空闲列表中移除超时、超过存活时间或不再指向 endpoint 的连接，返回需要关闭的连接
+func (c *Client) pruneLocked(now time.Time, endpoint string) []*searchdConn {
+	var stale []*searchdConn
+	idle := make([]*searchdConn, 0, len(c.pool.idle))
+
+	for _, conn := range c.pool.idle {
+		switch {
+		case c.pool.maxLifetime > 0 && now.Sub(conn.created) >= c.pool.maxLifetime:
+			c.pool.maxLifetimeClosed++
+		case c.pool.maxIdleTime > 0 && now.Sub(conn.idleSince) >= c.pool.maxIdleTime:
+			c.pool.maxIdleTimeClosed++
+		case conn.endpoint != endpoint:
+		default:
+			idle = append(idle, conn)
+			continue
+		}
+		stale = append(stale, conn)
+	}
+
+	c.pool.idle = idle
+	for range stale {
+		c.closedLocked()
+	}
+	return stale
+}
+
+// trimIdleLocked 关闭超出空闲连接数上限的最早归还的连接，返回需要关闭的连接
+func (c *Client) trimIdleLocked() []*searchdConn {
+	excess := len(c.pool.idle) - c.pool.maxIdle
+	if excess <= 0 {
+		return nil
+	}
+
+	stale := append([]*searchdConn(nil), c.pool.idle[:excess]...)
+	c.pool.idle = append([]*searchdConn(nil), c.pool.idle[excess:]...)
+	c.pool.maxIdleClosed += int64(excess)
+	for range stale {
+		c.closedLocked()
+	}
+	return stale
+}
+
+func closeConns(conns []*searchdConn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,279 @@
+package sphinx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowReply 在 replyOK 之前等待 d，让并发的请求同时占用连接
+func slowReply(d time.Duration) handler {
+	return func(cmd uint16, ver uint16, body []byte) (uint16, []byte) {
+		time.Sleep(d)
+		return replyOK(cmd, ver, body)
+	}
+}
+
+// newPooledClient 返回一个连接到 path 并已经 Open 的 Client
+func newPooledClient(t *testing.T, path string, maxOpen int, maxIdle int) *Client {
+	t.Helper()
+
+	c := NewClient()
+	c.SetServer(path, 0)
+	c.SetMaxOpenConns(maxOpen)
+	c.SetMaxIdleConns(maxIdle)
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPoolMaxOpen(t *testing.T) {
+	f, path := newUnixSearchd(t, slowReply(5*time.Millisecond))
+	c := newPooledClient(t, path, 3, 3)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Search(NewSearchRequest("test", "")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	st := c.Stats()
+	if f.connections() > 3 || st.OpenConnections > 3 || st.MaxOpenConnections != 3 {
+		t.Fatalf("%d connections accepted, stats %+v", f.connections(), st)
+	}
+	if st.InUse != 0 || st.Idle != st.OpenConnections || st.WaitCount == 0 || st.WaitDuration <= 0 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestPoolWaitContext(t *testing.T) {
+	_, path := newUnixSearchd(t, replyOK)
+	c := newPooledClient(t, path, 1, 1)
+	defer c.Close()
+
+	held, err := c.acquire(context.Background(), c.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.SearchContext(ctx, NewSearchRequest("test", ""))
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("deadline while waiting: %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = c.SearchContext(ctx, NewSearchRequest("test", ""))
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrNoClient) {
+		t.Fatalf("cancel while waiting: %v", err)
+	}
+
+	c.release(held, true)
+
+	st := c.Stats()
+	if st.OpenConnections != 1 || st.Idle != 1 || st.WaitCount != 2 {
+		t.Fatalf("stats after abandoned waits %+v", st)
+	}
+	if _, err := c.Search(NewSearchRequest("test", "")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 等待中的请求放弃的同时分到了连接，连接要还给连接池而不是泄漏
+func TestPoolWaitCancelRace(t *testing.T) {
+	_, path := newUnixSearchd(t, replyOK)
+	c := newPooledClient(t, path, 1, 1)
+	defer c.Close()
+
+	for i := 0; i < 200; i++ {
+		held, err := c.acquire(context.Background(), c.config())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		got := make(chan *searchdConn)
+		go func() {
+			conn, _ := c.acquire(ctx, c.config())
+			got <- conn
+		}()
+		for c.Stats().WaitCount <= int64(i) {
+			time.Sleep(10 * time.Microsecond)
+		}
+		released := make(chan struct{})
+		go func() {
+			c.release(held, true)
+			close(released)
+		}()
+		cancel()
+		if conn := <-got; conn != nil {
+			c.release(conn, true)
+		}
+		<-released
+
+		if st := c.Stats(); st.OpenConnections != 1 || st.InUse != 0 {
+			t.Fatalf("iteration %d: stats %+v", i, st)
+		}
+	}
+}
+
+func TestPoolIdleTrim(t *testing.T) {
+	_, path := newUnixSearchd(t, replyOK)
+	c := newPooledClient(t, path, 0, 4)
+	defer c.Close()
+
+	var conns []*searchdConn
+	for i := 0; i < 4; i++ {
+		conn, err := c.acquire(context.Background(), c.config())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	if st := c.Stats(); st.InUse != 4 || st.Idle != 0 {
+		t.Fatalf("stats with 4 in use %+v", st)
+	}
+	for _, conn := range conns {
+		c.release(conn, true)
+	}
+	if st := c.Stats(); st.Idle != 4 || st.OpenConnections != 4 {
+		t.Fatalf("stats after release %+v", st)
+	}
+
+	c.SetMaxIdleConns(1)
+	if st := c.Stats(); st.Idle != 1 || st.OpenConnections != 1 || st.MaxIdleClosed != 3 {
+		t.Fatalf("stats after trim %+v", st)
+	}
+
+	// 空闲连接数上限不能超过打开连接数上限
+	c.SetMaxIdleConns(4)
+	c.SetMaxOpenConns(2)
+	c.mu.Lock()
+	maxIdle := c.pool.maxIdle
+	c.mu.Unlock()
+	if maxIdle != 2 {
+		t.Fatalf("maxIdle %d with maxOpen 2", maxIdle)
+	}
+}
+
+func TestPoolIdleTimeAndLifetime(t *testing.T) {
+	_, path := newUnixSearchd(t, slowReply(2*time.Millisecond))
+	c := newPooledClient(t, path, 0, 2)
+	defer c.Close()
+
+	c.SetConnMaxIdleTime(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := c.Search(NewSearchRequest("test", "")); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.MaxIdleTimeClosed != 1 || st.Idle != 1 {
+		t.Fatalf("stats after idle timeout %+v", st)
+	}
+
+	c.SetConnMaxIdleTime(0)
+	time.Sleep(5 * time.Millisecond)
+	c.SetConnMaxLifetime(time.Millisecond)
+	if st := c.Stats(); st.MaxLifetimeClosed != 1 || st.Idle != 0 {
+		t.Fatalf("stats after lifetime %+v", st)
+	}
+	if _, err := c.Search(NewSearchRequest("test", "")); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.MaxLifetimeClosed != 2 || st.OpenConnections != 0 {
+		t.Fatalf("expired connection returned to the pool %+v", st)
+	}
+}
+
+func TestPoolCloseInUse(t *testing.T) {
+	_, path := newUnixSearchd(t, replyOK)
+	c := newPooledClient(t, path, 0, 2)
+
+	held, err := c.acquire(context.Background(), c.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.OpenConnections != 1 || st.InUse != 1 || st.Idle != 0 {
+		t.Fatalf("stats after Close %+v", st)
+	}
+
+	c.release(held, true)
+	if st := c.Stats(); st.OpenConnections != 0 {
+		t.Fatalf("connection kept after Close %+v", st)
+	}
+	if err := c.Close(); !errors.Is(err, ErrParameter) {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestPoolDiscardsBrokenConns(t *testing.T) {
+	f, path := newUnixSearchd(t, replyOK)
+	c := newPooledClient(t, path, 0, 2)
+	defer c.Close()
+
+	conn, err := c.acquire(context.Background(), c.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.release(conn, false)
+	if st := c.Stats(); st.OpenConnections != 0 {
+		t.Fatalf("errored connection kept %+v", st)
+	}
+
+	if _, err := c.Search(NewSearchRequest("test", "")); err != nil {
+		t.Fatal(err)
+	}
+	before := f.connections()
+	f.dropConns()
+
+	// 空闲连接已经被服务端关闭，取出时检查出来并重新建立
+	if _, err := c.Search(NewSearchRequest("test", "")); err != nil {
+		t.Fatal(err)
+	}
+	if f.connections() != before+1 {
+		t.Fatalf("%d new connections", f.connections()-before)
+	}
+}
+
+func TestAlive(t *testing.T) {
+	f, path := newUnixSearchd(t, replyOK)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 握手版本号还没读，连接上有未读数据，说明数据流已经不同步
+	time.Sleep(10 * time.Millisecond)
+	if alive(conn) {
+		t.Fatal("connection with unread data reported alive")
+	}
+
+	io.ReadFull(conn, make([]byte, 4))
+	if !alive(conn) {
+		t.Fatal("idle connection reported dead")
+	}
+
+	f.dropConns()
+	time.Sleep(10 * time.Millisecond)
+	if alive(conn) {
+		t.Fatal("connection closed by the server reported alive")
+	}
+}
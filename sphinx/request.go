@@ -0,0 +1,752 @@
+package sphinx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// SearchRequest 一条查询及其匹配模式、过滤、排序和分组等设置，与连接无关。
+// 按值传递，Clone 之后的副本互不影响，可以在多个 goroutine 中分别构造后交给同一个 Client 执行
+type SearchRequest struct {
+	query         string
+	index         string
+	comment       string
+	offset        uint
+	limit         uint
+	mode          int
+	ranker        int
+	rankexpr      string
+	sort          int
+	sortby        string
+	weights       []int
+	min_id        uint
+	max_id        uint
+	filters       []Filter
+	anchor        *Anchor
+	groupfunc     int
+	groupby       string
+	maxmatches    uint
+	groupsort     string
+	cutoff        uint
+	retrycount    int
+	retrydelay    int
+	groupdistinct string
+	indexweights  []Indexweight
+	maxquerytime  uint
+	fieldweights  []Fieldweights
+	arrayresult   bool
+	selectlist    string
+	queryflags    uint32
+	predictedtime int
+	outerorderby  string
+	outeroffset   int
+	outerlimit    int
+	hasouter      bool
+	overrides     []Override
+}
+
+// NewSearchRequest 返回一条使用默认设置的查询，index 为空时查询所有索引
+func NewSearchRequest(query string, index string) SearchRequest {
+	return SearchRequest{
+		query:      query,
+		index:      index,
+		limit:      20,
+		mode:       SPH_MATCH_ALL,
+		ranker:     SPH_RANK_PROXIMITY_BM25,
+		sort:       SPH_SORT_RELEVANCE,
+		groupfunc:  SPH_GROUPBY_DAY,
+		maxmatches: 1000,
+		groupsort:  "@group desc",
+		selectlist: "*",
+		queryflags: 1 << 6,
+	}
+}
+
+// SetComment 设置查询注释，会出现在 searchd 的查询日志中
+func (q *SearchRequest) SetComment(comment string) {
+	q.comment = comment
+}
+
+// Clone 复制一份查询，切片单独拷贝，之后对任一份的 Set* 调用都不会影响另一份
+func (q SearchRequest) Clone() SearchRequest {
+	q.weights = append([]int(nil), q.weights...)
+	q.filters = append([]Filter(nil), q.filters...)
+	q.overrides = append([]Override(nil), q.overrides...)
+	q.indexweights = append([]Indexweight(nil), q.indexweights...)
+	q.fieldweights = append([]Fieldweights(nil), q.fieldweights...)
+	return q
+}
+
+func setBit(flags uint32, bit uint, on bool) uint32 {
+	if on {
+		return flags | (1 << bit)
+	}
+	return flags &^ (1 << bit)
+}
+
+// SetQueryFlag 设置单次查询的标记，需要 search 协议 0x11E 及以上。支持的标记与取值：
+//
+//	reverse_scan       0 或 1
+//	sort_method        "pq" 或 "kbuffer"
+//	max_predicted_time 非负整数，单位毫秒
+//	boolean_simplify   bool
+//	idf                "normalized"、"plain"、"tfidf_normalized" 或 "tfidf_unnormalized"
+//	global_idf         bool
+//	low_priority       bool
+func (q *SearchRequest) SetQueryFlag(name string, value interface{}) error {
+	flags := q.queryflags
+	bad := fmt.Errorf("%s, %w: %s=%v", "SetQueryFlag", ErrParameter, name, value)
+
+	switch name {
+	case "reverse_scan":
+		v, ok := value.(int)
+		if !ok || (v != 0 && v != 1) {
+			return bad
+		}
+		flags = setBit(flags, 0, v == 1)
+	case "sort_method":
+		v, ok := value.(string)
+		if !ok || (v != "pq" && v != "kbuffer") {
+			return bad
+		}
+		flags = setBit(flags, 1, v == "kbuffer")
+	case "max_predicted_time":
+		v, ok := value.(int)
+		if !ok || v < 0 {
+			return bad
+		}
+		flags = setBit(flags, 2, v > 0)
+		q.predictedtime = v
+	case "boolean_simplify":
+		v, ok := value.(bool)
+		if !ok {
+			return bad
+		}
+		flags = setBit(flags, 3, v)
+	case "idf":
+		v, _ := value.(string)
+		switch v {
+		case "normalized", "plain":
+			flags = setBit(flags, 4, v == "plain")
+		case "tfidf_normalized", "tfidf_unnormalized":
+			flags = setBit(flags, 6, v == "tfidf_normalized")
+		default:
+			return bad
+		}
+	case "global_idf":
+		v, ok := value.(bool)
+		if !ok {
+			return bad
+		}
+		flags = setBit(flags, 5, v)
+	case "low_priority":
+		v, ok := value.(bool)
+		if !ok {
+			return bad
+		}
+		flags = setBit(flags, 8, v)
+	default:
+		return bad
+	}
+
+	q.queryflags = flags
+	return nil
+}
+
+// SetMaxPredictedTime 设置预测的最大查询时间（毫秒），等同于 SetQueryFlag("max_predicted_time", msec)
+func (q *SearchRequest) SetMaxPredictedTime(msec int) error {
+	return q.SetQueryFlag("max_predicted_time", msec)
+}
+
+func (q *SearchRequest) ResetQueryFlag() {
+	q.queryflags = 1 << 6
+	q.predictedtime = 0
+}
+
+// SetOuterSelect 对内层查询的结果再做一次排序和分页，需要 search 协议 0x11E 及以上
+func (q *SearchRequest) SetOuterSelect(orderby string, offset int, limit int) error {
+	if offset < 0 || limit <= 0 {
+		return fmt.Errorf("%s, %w: offset %d limit %d", "SetOuterSelect", ErrParameter, offset, limit)
+	}
+
+	q.outerorderby = orderby
+	q.outeroffset = offset
+	q.outerlimit = limit
+	q.hasouter = true
+	return nil
+}
+
+func (q *SearchRequest) ResetOuterSelect() {
+	q.outerorderby = ""
+	q.outeroffset = 0
+	q.outerlimit = 0
+	q.hasouter = false
+}
+
+func (q *SearchRequest) SetLimits(offset uint, limit uint, max uint, cutoff uint) {
+	q.offset = offset
+	q.limit = limit
+	q.maxmatches = max
+	q.cutoff = cutoff
+}
+
+func (q *SearchRequest) SetMaxQueryTime(max uint) {
+	q.maxquerytime = max
+}
+
+func (q *SearchRequest) SetMatchMode(mode int) error {
+	if mode == SPH_MATCH_ALL || mode == SPH_MATCH_ANY || mode == SPH_MATCH_PHRASE || mode == SPH_MATCH_BOOLEAN ||
+		mode == SPH_MATCH_EXTENDED ||
+		mode == SPH_MATCH_EXTENDED2 {
+		q.mode = mode
+		return nil
+	} else {
+		return fmt.Errorf("%w:%s", ErrParameter, "SetMatchMode")
+	}
+}
+
+// SetRankingMode 设置排序器，SPH_RANK_EXPR 和 SPH_RANK_EXPORT 需要额外传入排序表达式，
+// 例如 SetRankingMode(SPH_RANK_EXPR, "sum(lcs*user_weight)*1000+bm25")
+func (q *SearchRequest) SetRankingMode(ranker int, rankexpr ...string) error {
+	if ranker < SPH_RANK_PROXIMITY_BM25 || ranker > SPH_RANK_EXPORT || len(rankexpr) > 1 {
+		return fmt.Errorf("%w:%s", ErrParameter, "SetRankingMode")
+	}
+
+	expr := ""
+	if len(rankexpr) == 1 {
+		expr = rankexpr[0]
+	}
+
+	if (ranker == SPH_RANK_EXPR || ranker == SPH_RANK_EXPORT) && expr == "" {
+		return fmt.Errorf("%w:%s", ErrParameter, "SetRankingMode empty ranking expression")
+	}
+
+	q.ranker = ranker
+	q.rankexpr = expr
+	return nil
+}
+
+func (q *SearchRequest) SetSortMode(mode int, sortby string) error {
+	if mode == SPH_SORT_RELEVANCE || mode == SPH_SORT_ATTR_DESC || mode == SPH_SORT_ATTR_ASC || mode == SPH_SORT_TIME_SEGMENTS ||
+		mode == SPH_SORT_EXTENDED ||
+		mode == SPH_SORT_EXPR {
+		q.sort = mode
+		q.sortby = sortby
+		return nil
+	}
+
+	return fmt.Errorf("%w:%s", ErrParameter, "SetSortMode")
+}
+
+func (q *SearchRequest) SetWeights(weights []int) {
+	q.weights = weights
+}
+
+func (q *SearchRequest) SetFieldWeights(weights []Fieldweights) {
+	q.fieldweights = append(q.fieldweights[:len(q.fieldweights):len(q.fieldweights)], weights...)
+}
+
+func (q *SearchRequest) SetIndexWeights(weights []Indexweight) {
+	q.indexweights = append(q.indexweights[:len(q.indexweights):len(q.indexweights)], weights...)
+}
+
+func (q *SearchRequest) SetIDRange(min uint, max uint) error {
+	if min >= max {
+		return fmt.Errorf("%s, %w: [%d >= %d]", "SetIDRange", ErrParameter, min, max)
+	} else {
+		q.min_id = min
+		q.max_id = max
+		return nil
+	}
+}
+
+// addFilter 追加一个过滤条件。按容量截断后再追加，
+// 保证直接赋值得到的副本各自追加时不会写到同一个底层数组
+func (q *SearchRequest) addFilter(f Filter) {
+	q.filters = append(q.filters[:len(q.filters):len(q.filters)], f)
+}
+
+func (q *SearchRequest) SetFilter(attribute string, values []int, exclude bool) {
+	f := Filter{
+		Type:    SPH_FILTER_VALUES,
+		Attr:    attribute,
+		Exclude: exclude,
+		Values:  values,
+	}
+	q.addFilter(f)
+}
+
+func (q *SearchRequest) SetFilterRange(attribute string, min uint, max uint, exclude bool) error {
+	if min >= max {
+		return fmt.Errorf("%s, %w: [%d >= %d]", "SetFilterRange", ErrParameter, min, max)
+	}
+
+	f := Filter{
+		Type:    SPH_FILTER_RANGE,
+		Attr:    attribute,
+		Exclude: exclude,
+		Min:     min,
+		Max:     max,
+	}
+	q.addFilter(f)
+	return nil
+}
+
+func (q *SearchRequest) SetFilterFloatRange(attribute string, min float32, max float32, exclude bool) error {
+
+	if min >= max {
+		return fmt.Errorf("%s, %w: [%v >= %v]", "SetFilterFloatRange", ErrParameter, min, max)
+	}
+
+	f := Filter{
+		Type:      SPH_FILTER_FLOATRANGE,
+		Attr:      attribute,
+		Exclude:   exclude,
+		Min_float: min,
+		Max_float: max,
+	}
+	q.addFilter(f)
+
+	return nil
+}
+
+// SetFilterString 按字符串属性过滤，需要 search 协议 0x11E 及以上
+func (q *SearchRequest) SetFilterString(attribute string, value string, exclude bool) error {
+	if attribute == "" {
+		return fmt.Errorf("%s, %w: empty attribute", "SetFilterString", ErrParameter)
+	}
+
+	f := Filter{
+		Type:    SPH_FILTER_STRING,
+		Attr:    attribute,
+		Exclude: exclude,
+		Strings: []string{value},
+	}
+	q.addFilter(f)
+	return nil
+}
+
+// SetFilterStringList 按字符串属性是否在 values 中过滤。
+// sphinx 2.2 不认识这个过滤类型，只能在 SetDialect(DialectManticore) 时使用
+func (q *SearchRequest) SetFilterStringList(attribute string, values []string, exclude bool) error {
+	if attribute == "" {
+		return fmt.Errorf("%s, %w: empty attribute", "SetFilterStringList", ErrParameter)
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("%s, %w: empty values", "SetFilterStringList", ErrParameter)
+	}
+
+	f := Filter{
+		Type:    SPH_FILTER_STRING_LIST,
+		Attr:    attribute,
+		Exclude: exclude,
+		Strings: values,
+	}
+	q.addFilter(f)
+	return nil
+}
+
+// SetGeoAnchor 设置地理位置锚点，lat/long 单位为弧度。
+// 设置后每条结果都会带上计算出的 @geodist（单位米），可以用于 SetFilterFloatRange 和 SetSortMode
+func (q *SearchRequest) SetGeoAnchor(attrlat string, attrlong string, lat float32, long float32) {
+	q.anchor = &Anchor{
+		AttrLat:  attrlat,
+		AttrLong: attrlong,
+		Lat:      lat,
+		Long:     long,
+	}
+}
+
+func (q *SearchRequest) SetGroupBy(attribute string, fun int, groupsort string) error {
+
+	if fun == SPH_GROUPBY_DAY || fun == SPH_GROUPBY_WEEK || fun == SPH_GROUPBY_MONTH || fun == SPH_GROUPBY_YEAR ||
+		fun == SPH_GROUPBY_ATTR ||
+		fun == SPH_GROUPBY_ATTRPAIR {
+		q.groupby = attribute
+		q.groupfunc = fun
+		q.groupsort = groupsort
+		return nil
+	} else {
+		return fmt.Errorf(" %w: %s", ErrParameter, "SetGroupBy")
+	}
+
+}
+
+func (q *SearchRequest) SetGroupDistinct(attribute string) {
+	q.groupdistinct = attribute
+}
+
+func (q *SearchRequest) SetRetries(count int, delay int) {
+	q.retrycount = count
+	q.retrydelay = delay
+}
+
+func (q *SearchRequest) SetArrayResult(arrayresult bool) {
+	q.arrayresult = arrayresult
+}
+
+// SetSelect 设置 select 列表，可以在服务端计算表达式属性，
+// 例如 "*, price*0.9 AS discounted, IF(stock>0,1,0) AS instock"，计算结果按别名出现在 Matches.Attrs 中
+func (q *SearchRequest) SetSelect(selectlist string) {
+	q.selectlist = selectlist
+}
+
+// SetOverride 为单次查询临时覆盖指定文档的属性值，覆盖后的值会参与过滤、排序和表达式计算。
+// attrType 支持 SPH_ATTR_INTEGER、SPH_ATTR_TIMESTAMP、SPH_ATTR_BOOL、SPH_ATTR_FLOAT 和 SPH_ATTR_BIGINT，
+// values 的 key 为文档 id，值为任意整数或浮点数类型，SPH_ATTR_BOOL 还可以用 bool。
+// 值必须能用线上的宽度表示：SPH_ATTR_BIGINT 为 int64，SPH_ATTR_FLOAT 为 float32，其他类型为 32 位
+func (q *SearchRequest) SetOverride(attr string, attrType int, values map[uint64]interface{}) error {
+	if attrType != SPH_ATTR_INTEGER && attrType != SPH_ATTR_TIMESTAMP && attrType != SPH_ATTR_BOOL &&
+		attrType != SPH_ATTR_FLOAT &&
+		attrType != SPH_ATTR_BIGINT {
+		return fmt.Errorf("%s, %w: attr type %d", "SetOverride", ErrParameter, attrType)
+	}
+
+	vals := make(map[uint64]interface{}, len(values))
+	for id, v := range values {
+		var iv int64
+		var fv float64
+		inRange, isFloat := true, false
+		switch n := v.(type) {
+		case bool:
+			if attrType != SPH_ATTR_BOOL {
+				return fmt.Errorf("%s, %w: doc %d value %T", "SetOverride", ErrParameter, id, v)
+			}
+			if n {
+				iv, fv = 1, 1
+			}
+		case int:
+			iv, fv = int64(n), float64(n)
+		case int32:
+			iv, fv = int64(n), float64(n)
+		case int64:
+			iv, fv = n, float64(n)
+		case uint:
+			iv, fv, inRange = int64(n), float64(n), uint64(n) <= math.MaxInt64
+		case uint32:
+			iv, fv = int64(n), float64(n)
+		case uint64:
+			iv, fv, inRange = int64(n), float64(n), n <= math.MaxInt64
+		case float32:
+			fv, isFloat = float64(n), true
+		case float64:
+			fv, isFloat = n, true
+		default:
+			return fmt.Errorf("%s, %w: doc %d value %T", "SetOverride", ErrParameter, id, v)
+		}
+
+		// 超出 int64 范围的浮点数转成整数的结果不确定
+		if isFloat {
+			inRange = fv >= math.MinInt64 && fv < math.MaxInt64
+			iv = int64(fv)
+		}
+
+		switch attrType {
+		case SPH_ATTR_FLOAT:
+			inRange = math.Abs(fv) <= math.MaxFloat32 || math.IsInf(fv, 0)
+		case SPH_ATTR_BIGINT:
+		default:
+			// 线上按 32 位发送，有符号和无符号的取值都可以
+			inRange = inRange && iv >= math.MinInt32 && iv <= math.MaxUint32
+		}
+		if !inRange {
+			return fmt.Errorf("%s, %w: doc %d value %v out of range", "SetOverride", ErrParameter, id, v)
+		}
+
+		if attrType == SPH_ATTR_FLOAT {
+			vals[id] = float32(fv)
+		} else {
+			vals[id] = iv
+		}
+	}
+
+	o := Override{Attr: attr, Type: attrType, Values: vals}
+	overrides := make([]Override, 0, len(q.overrides)+1)
+	for _, v := range q.overrides {
+		if v.Attr != attr {
+			overrides = append(overrides, v)
+		}
+	}
+	q.overrides = append(overrides, o)
+	return nil
+}
+
+func (q *SearchRequest) ResetOverrides() {
+	q.overrides = nil
+}
+
+func (q *SearchRequest) ResetFilters() {
+	q.filters = []Filter{}
+	q.anchor = nil
+}
+
+func (q *SearchRequest) ResetGroupBy() {
+	q.groupby = ""
+	q.groupfunc = SPH_GROUPBY_DAY
+	q.groupsort = "@group desc"
+	q.groupdistinct = ""
+}
+
+// encode 按 ver 对应的布局编码一条查询，dialect 决定能否使用方言特有的过滤类型
+func (q SearchRequest) encode(ver uint16, dialect Dialect) ([]byte, error) {
+	query, index, comment := q.query, q.index, q.comment
+
+	if ver < verSearchFlags && (q.queryflags != 1<<6 || q.predictedtime > 0 || q.hasouter) {
+		return nil, fmt.Errorf("%w: query flags and outer select need protocol 0x%x, using 0x%x", ErrVersions, verSearchFlags, ver)
+	}
+
+	//$this->_query_flags, $this->_offset, $this->_limit, $this->_mode, $this->_ranker, $this->_sort
+	buff := bytes.NewBuffer([]byte{})
+	if ver >= verSearchFlags {
+		binary.Write(buff, binary.BigEndian, q.queryflags)
+	}
+	binary.Write(buff, binary.BigEndian, int32(q.offset))
+	binary.Write(buff, binary.BigEndian, int32(q.limit))
+	binary.Write(buff, binary.BigEndian, int32(q.mode))
+	binary.Write(buff, binary.BigEndian, int32(q.ranker))
+	if q.ranker == SPH_RANK_EXPR || q.ranker == SPH_RANK_EXPORT {
+		if ver < verSearchRankExpr {
+			return nil, fmt.Errorf("%w: ranking expression needs protocol 0x%x, using 0x%x", ErrVersions, verSearchRankExpr, ver)
+		}
+		//$req .= pack ( "N", strlen($this->_rankexpr) ) . $this->_rankexpr;
+		binary.Write(buff, binary.BigEndian, int32(len(q.rankexpr)))
+		buff.Write([]byte(q.rankexpr))
+	}
+	binary.Write(buff, binary.BigEndian, int32(q.sort))
+	//$req .= pack ( "N", strlen($this->_sortby) ) . $this->_sortby;
+	binary.Write(buff, binary.BigEndian, int32(len(q.sortby)))
+	buff.Write([]byte(q.sortby))
+	//$req .= pack ( "N", strlen($query) ) . $query
+	binary.Write(buff, binary.BigEndian, int32(len(query)))
+	buff.Write([]byte(query))
+
+	/*	$req .= pack ( "N", count($this->_weights) ); // weights
+		foreach ( $this->_weights as $weight )
+		$req .= pack ( "N", (int)$weight );*/
+
+	binary.Write(buff, binary.BigEndian, int32(len(q.weights)))
+	for _, v := range q.weights {
+		binary.Write(buff, binary.BigEndian, int32(v))
+	}
+
+	//$req .= pack ( "N", strlen($index) ) . $index; // indexes
+
+	binary.Write(buff, binary.BigEndian, int32(len(index)))
+	buff.Write([]byte(index))
+
+	//$req .= pack ( "N", 1 ); // id64 range marker
+	binary.Write(buff, binary.BigEndian, int32(1))
+
+	//$req .= sphPack64 ( $this->_min_id ) . sphPack64 ( $this->_max_id ); // id64 range
+	binary.Write(buff, binary.BigEndian, int64(q.min_id))
+	binary.Write(buff, binary.BigEndian, int64(q.max_id))
+
+	// filters
+	//$req .= pack ( "N", count($this->_filters) );
+
+	binary.Write(buff, binary.BigEndian, int32(len(q.filters)))
+
+	for _, v := range q.filters {
+		//$req .= pack ( "N", strlen($filter["attr"]) ) . $filter["attr"];
+		binary.Write(buff, binary.BigEndian, int32(len(v.Attr)))
+		buff.Write([]byte(v.Attr))
+		//$req .= pack ( "N", $filter["type"] );
+		binary.Write(buff, binary.BigEndian, int32(v.Type))
+
+		switch v.Type {
+		case SPH_FILTER_VALUES:
+			//$req .= pack ( "N", count($filter["values"]) );
+			binary.Write(buff, binary.BigEndian, int32(len(v.Values)))
+
+			for _, vv := range v.Values {
+				if ver >= verSearch64Filters {
+					//$req .= sphPackI64 ( $value );
+					binary.Write(buff, binary.BigEndian, int64(vv))
+				} else {
+					binary.Write(buff, binary.BigEndian, int32(vv))
+				}
+			}
+			break
+		case SPH_FILTER_RANGE:
+			if ver >= verSearch64Filters {
+				//$req .= sphPackI64 ( $filter["min"] ) . sphPackI64 ( $filter["max"] );
+				binary.Write(buff, binary.BigEndian, int64(v.Min))
+				binary.Write(buff, binary.BigEndian, int64(v.Max))
+			} else {
+				//$req .= pack ( "NN", $filter["min"], $filter["max"] );
+				binary.Write(buff, binary.BigEndian, int32(v.Min))
+				binary.Write(buff, binary.BigEndian, int32(v.Max))
+			}
+			break
+		case SPH_FILTER_FLOATRANGE:
+			//$req .= $this->_PackFloat ( $filter["min"] ) . $this->_PackFloat ( $filter["max"] );
+			binary.Write(buff, binary.BigEndian, v.Min_float)
+			binary.Write(buff, binary.BigEndian, v.Max_float)
+			break
+		case SPH_FILTER_STRING, SPH_FILTER_STRING_LIST:
+			if ver < verSearchFlags {
+				return nil, fmt.Errorf("%w: string filter on %s needs protocol 0x%x, using 0x%x", ErrVersions, v.Attr, verSearchFlags, ver)
+			}
+			if v.Type == SPH_FILTER_STRING_LIST && dialect != DialectManticore {
+				return nil, fmt.Errorf("%w: string list filter on %s needs DialectManticore", ErrVersions, v.Attr)
+			}
+			if v.Type == SPH_FILTER_STRING {
+				//$req .= pack ( "N", strlen($filter["value"]) ) . $filter["value"];
+				binary.Write(buff, binary.BigEndian, int32(len(v.Strings[0])))
+				buff.Write([]byte(v.Strings[0]))
+				break
+			}
+			binary.Write(buff, binary.BigEndian, int32(len(v.Strings)))
+			for _, vv := range v.Strings {
+				binary.Write(buff, binary.BigEndian, int32(len(vv)))
+				buff.Write([]byte(vv))
+			}
+			break
+		default:
+			{
+			}
+			break
+		}
+
+		if v.Exclude {
+			binary.Write(buff, binary.BigEndian, int32(1))
+		} else {
+			binary.Write(buff, binary.BigEndian, int32(0))
+		}
+
+	}
+
+	//	$req .= pack ( "NN", $this->_groupfunc, strlen($this->_groupby) ) . $this->_groupby;
+	binary.Write(buff, binary.BigEndian, int32(q.groupfunc))
+	binary.Write(buff, binary.BigEndian, int32(len(q.groupby)))
+	buff.Write([]byte(q.groupby))
+
+	//$req .= pack ( "N", $this->_maxmatches );
+	binary.Write(buff, binary.BigEndian, int32(q.maxmatches))
+
+	//$req .= pack ( "N", strlen($this->_groupsort) ) . $this->_groupsort;
+	binary.Write(buff, binary.BigEndian, int32(len(q.groupsort)))
+	buff.Write([]byte(q.groupsort))
+
+	//$req .= pack ( "NNN", $this->_cutoff, $this->_retrycount, $this->_retrydelay );
+	binary.Write(buff, binary.BigEndian, int32(q.cutoff))
+	binary.Write(buff, binary.BigEndian, int32(q.retrycount))
+	binary.Write(buff, binary.BigEndian, int32(q.retrydelay))
+
+	//$req .= pack ( "N", strlen($this->_groupdistinct) ) . $this->_groupdistinct;
+	binary.Write(buff, binary.BigEndian, int32(len(q.groupdistinct)))
+	buff.Write([]byte(q.groupdistinct))
+
+	// anchor point
+	if q.anchor == nil {
+		//$req .= pack ( "N", 0 );
+		binary.Write(buff, binary.BigEndian, int32(0))
+	} else {
+		a := q.anchor
+		//$req .= pack ( "N", 1 );
+		binary.Write(buff, binary.BigEndian, int32(1))
+		//$req .= pack ( "N", strlen($a["attrlat"]) ) . $a["attrlat"];
+		binary.Write(buff, binary.BigEndian, int32(len(a.AttrLat)))
+		buff.Write([]byte(a.AttrLat))
+		//$req .= pack ( "N", strlen($a["attrlong"]) ) . $a["attrlong"];
+		binary.Write(buff, binary.BigEndian, int32(len(a.AttrLong)))
+		buff.Write([]byte(a.AttrLong))
+		//$req .= $this->_PackFloat ( $a["lat"] ) . $this->_PackFloat ( $a["long"] );
+		binary.Write(buff, binary.BigEndian, a.Lat)
+		binary.Write(buff, binary.BigEndian, a.Long)
+	}
+
+	// per-index weights
+	binary.Write(buff, binary.BigEndian, int32(len(q.indexweights)))
+
+	for _, v := range q.indexweights {
+		//$req .= pack ( "N", strlen($idx) ) . $idx . pack ( "N", $weight );
+		binary.Write(buff, binary.BigEndian, int32(len(v.Idx)))
+		buff.Write([]byte(v.Idx))
+		binary.Write(buff, binary.BigEndian, int32(v.Weight))
+
+	}
+
+	//$req .= pack ( "N", $this->_maxquerytime );
+	binary.Write(buff, binary.BigEndian, int32(q.maxquerytime))
+
+	// per-field weights
+	//$req .= pack ( "N", count($this->_fieldweights) );
+	binary.Write(buff, binary.BigEndian, int32(len(q.fieldweights)))
+
+	for _, v := range q.fieldweights {
+		//$req .= pack ( "N", strlen($field) ) . $field . pack ( "N", $weight );
+		binary.Write(buff, binary.BigEndian, int32(len(v.Name)))
+		buff.Write([]byte(v.Name))
+		binary.Write(buff, binary.BigEndian, int32(v.Weight))
+	}
+
+	//$req .= pack ( "N", strlen($comment) ) . $comment;
+	binary.Write(buff, binary.BigEndian, int32(len(comment)))
+	buff.Write([]byte(comment))
+
+	if ver < verSearchOverrides {
+		if len(q.overrides) > 0 || q.selectlist != "*" {
+			return nil, fmt.Errorf("%w: overrides and select-list need protocol 0x%x, using 0x%x", ErrVersions, verSearchOverrides, ver)
+		}
+		return buff.Bytes(), nil
+	}
+
+	// attribute overrides
+	//$req .= pack ( "N", count($this->_overrides) );
+	binary.Write(buff, binary.BigEndian, int32(len(q.overrides)))
+
+	for _, v := range q.overrides {
+		//$req .= pack ( "N", strlen($entry["attr"]) ) . $entry["attr"];
+		binary.Write(buff, binary.BigEndian, int32(len(v.Attr)))
+		buff.Write([]byte(v.Attr))
+		//$req .= pack ( "NN", $entry["type"], count($entry["values"]) );
+		binary.Write(buff, binary.BigEndian, int32(v.Type))
+		binary.Write(buff, binary.BigEndian, int32(len(v.Values)))
+
+		for id, val := range v.Values {
+			//$req .= sphPackU64 ( $id );
+			binary.Write(buff, binary.BigEndian, id)
+
+			switch v.Type {
+			case SPH_ATTR_FLOAT:
+				binary.Write(buff, binary.BigEndian, val.(float32))
+			case SPH_ATTR_BIGINT:
+				binary.Write(buff, binary.BigEndian, val.(int64))
+			default:
+				binary.Write(buff, binary.BigEndian, int32(val.(int64)))
+			}
+		}
+	}
+
+	// select-list
+	//$req .= pack ( "N", strlen($this->_select) ) . $this->_select;
+	binary.Write(buff, binary.BigEndian, int32(len(q.selectlist)))
+	buff.Write([]byte(q.selectlist))
+
+	if ver >= verSearchFlags {
+		// max_predicted_time
+		if q.predictedtime > 0 {
+			//$req .= pack ( "N", (int)$this->_predictedtime );
+			binary.Write(buff, binary.BigEndian, int32(q.predictedtime))
+		}
+
+		// outer select
+		//$req .= pack ( "N", strlen($this->_outerorderby) ) . $this->_outerorderby;
+		binary.Write(buff, binary.BigEndian, int32(len(q.outerorderby)))
+		buff.Write([]byte(q.outerorderby))
+		//$req .= pack ( "NN", $this->_outeroffset, $this->_outerlimit );
+		binary.Write(buff, binary.BigEndian, int32(q.outeroffset))
+		binary.Write(buff, binary.BigEndian, int32(q.outerlimit))
+		//$req .= pack ( "N", $this->_hasouter );
+		if q.hasouter {
+			binary.Write(buff, binary.BigEndian, int32(1))
+		} else {
+			binary.Write(buff, binary.BigEndian, int32(0))
+		}
+	}
+
+	return buff.Bytes(), nil
+}
@@ -0,0 +1,119 @@
+package sphinx
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestSetFilterString(t *testing.T) {
+	q := NewSearchRequest("test", "")
+	if err := q.SetFilterString("", "alice", false); !errors.Is(err, ErrParameter) {
+		t.Fatalf("empty attribute: %v", err)
+	}
+	if err := q.SetFilterStringList("", []string{"alice"}, false); !errors.Is(err, ErrParameter) {
+		t.Fatalf("empty attribute: %v", err)
+	}
+	if err := q.SetFilterStringList("author", nil, false); !errors.Is(err, ErrParameter) {
+		t.Fatalf("empty values: %v", err)
+	}
+	if len(q.filters) != 0 {
+		t.Fatalf("rejected filters added: %+v", q.filters)
+	}
+
+	if err := q.SetFilterString("author", "alice", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.encode(verSearchFlags, DialectSphinx2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.encode(verSearchRankExpr, DialectSphinx099); !errors.Is(err, ErrVersions) {
+		t.Fatalf("string filter below 0x11E: %v", err)
+	}
+}
+
+// sphinx 2.2 不认识 SPH_FILTER_STRING_LIST，只有 manticore 方言下才发送
+func TestSetFilterStringListDialect(t *testing.T) {
+	q := NewSearchRequest("test", "")
+	if err := q.SetFilterStringList("author", []string{"alice", "bob"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dialect := range []Dialect{DialectAuto, DialectSphinx2, DialectSphinx3} {
+		if _, err := q.encode(verSearchFlags, dialect); !errors.Is(err, ErrVersions) {
+			t.Fatalf("dialect %d: %v", dialect, err)
+		}
+	}
+	if _, err := q.encode(verSearchFlags, DialectManticore); err != nil {
+		t.Fatal(err)
+	}
+
+	f, path := newUnixSearchd(t, replyOK)
+	c := NewClient()
+	c.SetServer(path, 0)
+	c.SetDialect(DialectSphinx2)
+	if _, err := c.Search(q); !errors.Is(err, ErrVersions) {
+		t.Fatalf("DialectSphinx2: %v", err)
+	}
+	if f.connections() != 0 {
+		t.Fatal("request sent for a filter the daemon does not know")
+	}
+
+	c.SetDialect(DialectManticore)
+	if _, err := c.Search(q); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetOverride(t *testing.T) {
+	tests := []struct {
+		attrType int
+		value    interface{}
+		want     interface{}
+	}{
+		{SPH_ATTR_BOOL, true, int64(1)},
+		{SPH_ATTR_BOOL, false, int64(0)},
+		{SPH_ATTR_INTEGER, -1, int64(-1)},
+		{SPH_ATTR_INTEGER, uint32(math.MaxUint32), int64(math.MaxUint32)},
+		{SPH_ATTR_TIMESTAMP, 1199145600.0, int64(1199145600)},
+		{SPH_ATTR_BIGINT, uint64(math.MaxInt64), int64(math.MaxInt64)},
+		{SPH_ATTR_BIGINT, int64(math.MinInt64), int64(math.MinInt64)},
+		{SPH_ATTR_FLOAT, 3, float32(3)},
+		{SPH_ATTR_FLOAT, 9.5, float32(9.5)},
+	}
+	for _, tt := range tests {
+		q := NewSearchRequest("test", "")
+		if err := q.SetOverride("attr", tt.attrType, map[uint64]interface{}{1: tt.value}); err != nil {
+			t.Errorf("type %d value %v: %v", tt.attrType, tt.value, err)
+			continue
+		}
+		if got := q.overrides[0].Values[1]; got != tt.want {
+			t.Errorf("type %d value %v: got %#v, want %#v", tt.attrType, tt.value, got, tt.want)
+		}
+	}
+
+	bad := []struct {
+		attrType int
+		value    interface{}
+	}{
+		{SPH_ATTR_INTEGER, true},
+		{SPH_ATTR_INTEGER, "1"},
+		{SPH_ATTR_INTEGER, int64(math.MaxUint32) + 1},
+		{SPH_ATTR_TIMESTAMP, int64(math.MinInt32) - 1},
+		{SPH_ATTR_BOOL, 1e10},
+		{SPH_ATTR_BIGINT, uint64(math.MaxInt64) + 1},
+		{SPH_ATTR_BIGINT, 1e19},
+		{SPH_ATTR_BIGINT, math.NaN()},
+		{SPH_ATTR_FLOAT, math.MaxFloat64},
+		{SPH_ATTR_STRING, 1},
+	}
+	for _, tt := range bad {
+		q := NewSearchRequest("test", "")
+		if err := q.SetOverride("attr", tt.attrType, map[uint64]interface{}{1: tt.value}); !errors.Is(err, ErrParameter) {
+			t.Errorf("type %d value %v: %v", tt.attrType, tt.value, err)
+		}
+		if len(q.overrides) != 0 {
+			t.Errorf("type %d value %v: override added", tt.attrType, tt.value)
+		}
+	}
+}
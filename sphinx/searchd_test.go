@@ -0,0 +1,236 @@
+package sphinx
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// handler 根据命令、版本和请求体生成 searchd 的响应状态和响应体
+type handler func(cmd uint16, ver uint16, body []byte) (uint16, []byte)
+
+// fakeSearchd 在本地 listener 上模拟 searchd：完成握手后按 handler 逐条回复命令，
+// PERSIST 命令不回复，与真实的 searchd 一致
+type fakeSearchd struct {
+	ln       net.Listener
+	handle   handler
+	accepted int32
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+func newFakeSearchd(t *testing.T, network string, address string, h handler) *fakeSearchd {
+	t.Helper()
+
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSearchd{ln: ln, handle: h, conns: map[net.Conn]bool{}}
+	t.Cleanup(f.close)
+
+	go f.serve()
+	return f
+}
+
+// newUnixSearchd 在临时目录的 unix socket 上启动 fakeSearchd，返回它和 socket 路径
+func newUnixSearchd(t *testing.T, h handler) (*fakeSearchd, string) {
+	path := filepath.Join(t.TempDir(), "searchd.sock")
+	return newFakeSearchd(t, "unix", path, h), path
+}
+
+func (f *fakeSearchd) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&f.accepted, 1)
+
+		f.mu.Lock()
+		f.conns[conn] = true
+		f.mu.Unlock()
+
+		go f.serveConn(conn)
+	}
+}
+
+func (f *fakeSearchd) serveConn(conn net.Conn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	conn.Write([]byte{0, 0, 0, 1})
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		return
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		cmd := binary.BigEndian.Uint16(header[0:2])
+		ver := binary.BigEndian.Uint16(header[2:4])
+		body := make([]byte, binary.BigEndian.Uint32(header[4:8]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		if cmd == SEARCHD_COMMAND_PERSIST {
+			continue
+		}
+
+		status, reply := f.handle(cmd, ver, body)
+		p := &packet{}
+		binary.Write(p, binary.BigEndian, status)
+		binary.Write(p, binary.BigEndian, ver)
+		p.u32(uint32(len(reply)))
+		p.Write(reply)
+		if _, err := conn.Write(p.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// dropConns 从服务端关闭所有已建立的连接，模拟 searchd 重启或回收空闲连接
+func (f *fakeSearchd) dropConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func (f *fakeSearchd) close() {
+	f.ln.Close()
+	f.dropConns()
+}
+
+func (f *fakeSearchd) connections() int {
+	return int(atomic.LoadInt32(&f.accepted))
+}
+
+// replyOK 对 search 命令按请求中的查询条数返回空结果集，对 status 命令返回一行计数器
+func replyOK(cmd uint16, ver uint16, body []byte) (uint16, []byte) {
+	p := &packet{}
+	switch cmd {
+	case SEARCHD_COMMAND_SEARCH:
+		for n := binary.BigEndian.Uint32(body); n > 0; n-- {
+			p.u32(SEARCHD_OK, 0, 0, 0, 1, 0, 0, 0, 0)
+		}
+	case SEARCHD_COMMAND_STATUS:
+		p.u32(1, 2).str("uptime", "1")
+	default:
+		return SEARCHD_ERROR, p.str("unknown command").Bytes()
+	}
+	return SEARCHD_OK, p.Bytes()
+}
+
+// captureSearchd 启动一个记录最后一条命令请求体的 fakeSearchd，reply 生成响应体
+func captureSearchd(t *testing.T, reply func(body []byte) []byte) (*Client, func() (uint16, uint16, []byte)) {
+	t.Helper()
+
+	type request struct {
+		cmd, ver uint16
+		body     []byte
+	}
+	got := make(chan request, 1)
+	_, path := newUnixSearchd(t, func(cmd uint16, ver uint16, body []byte) (uint16, []byte) {
+		got <- request{cmd, ver, body}
+		return SEARCHD_OK, reply(body)
+	})
+
+	c := NewClient()
+	c.SetServer(path, 0)
+	return c, func() (uint16, uint16, []byte) {
+		r := <-got
+		return r.cmd, r.ver, r.body
+	}
+}
+
+func TestSetServerAddress(t *testing.T) {
+	tests := []struct {
+		host    string
+		port    int
+		network string
+		address string
+	}{
+		{"127.0.0.1", 9312, "tcp", "127.0.0.1:9312"},
+		{"searchd.local", 9312, "tcp", "searchd.local:9312"},
+		{"::1", 9312, "tcp", "[::1]:9312"},
+		{"[::1]", 9312, "tcp", "[::1]:9312"},
+		{"[fe80::1%eth0]", 9312, "tcp", "[fe80::1%eth0]:9312"},
+		{"unix:///var/run/searchd.sock", 0, "unix", "/var/run/searchd.sock"},
+		{"/var/run/searchd.sock", 9312, "unix", "/var/run/searchd.sock"},
+	}
+
+	c := NewClient()
+	for _, tt := range tests {
+		c.SetServer(tt.host, tt.port)
+		cfg := c.config()
+		if cfg.network != tt.network || cfg.address != tt.address {
+			t.Errorf("SetServer(%q, %d) = %s %s, want %s %s", tt.host, tt.port, cfg.network, cfg.address, tt.network, tt.address)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	_, path := newUnixSearchd(t, replyOK)
+
+	for _, host := range []string{"unix://" + path, path} {
+		s := New()
+		s.SetServer(host, 0)
+		if _, err := s.Query("test", "", ""); err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+		if _, err := s.Status(); err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+	}
+}
+
+func TestIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	ln.Close()
+
+	f := newFakeSearchd(t, "tcp6", "[::1]:0", replyOK)
+	port := f.ln.Addr().(*net.TCPAddr).Port
+
+	for _, host := range []string{"[::1]", "::1"} {
+		c := NewClient()
+		c.SetServer(host, port)
+		if _, err := c.Status(); err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+	}
+}
+
+func TestSetEndpoint(t *testing.T) {
+	_, path := newUnixSearchd(t, replyOK)
+
+	c := NewClient()
+	for _, bad := range [][2]string{{"udp", path}, {"", path}, {"unix", ""}} {
+		if err := c.SetEndpoint(bad[0], bad[1]); !errors.Is(err, ErrParameter) {
+			t.Errorf("SetEndpoint(%q, %q) = %v, want ErrParameter", bad[0], bad[1], err)
+		}
+	}
+
+	if err := c.SetEndpoint("unix", path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Search(NewSearchRequest("test", "")); err != nil {
+		t.Fatal(err)
+	}
+}
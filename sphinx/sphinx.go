@@ -1,15 +1,10 @@
 package sphinx
 
 import (
-	"bytes"
-	"encoding/binary"
-	"encoding/hex"
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"net"
-	"strconv"
-	"time"
+	"regexp"
 )
 
 const (
@@ -19,13 +14,16 @@ const (
 	SEARCHD_COMMAND_EXCERPT  = 1
 	SEARCHD_COMMAND_UPDATE   = 2
 	SEARCHD_COMMAND_KEYWORDS = 3
+	SEARCHD_COMMAND_PERSIST  = 4
+	SEARCHD_COMMAND_STATUS   = 5
 
 	// current client-side command implementation versions
 
-	VER_COMMAND_SEARCH   = "0113"
-	VER_COMMAND_EXCERPT  = "0x100"
-	VER_COMMAND_UPDATE   = "0x101"
-	VER_COMMAND_KEYWORDS = "0x100"
+	VER_COMMAND_SEARCH   = 0x11E
+	VER_COMMAND_EXCERPT  = 0x104
+	VER_COMMAND_UPDATE   = 0x102
+	VER_COMMAND_KEYWORDS = 0x100
+	VER_COMMAND_STATUS   = 0x100
 
 	// known searchd status codes
 
@@ -48,6 +46,12 @@ const (
 	SPH_RANK_BM25           = 1
 	SPH_RANK_NONE           = 2
 	SPH_RANK_WORDCOUNT      = 3
+	SPH_RANK_PROXIMITY      = 4
+	SPH_RANK_MATCHANY       = 5
+	SPH_RANK_FIELDMASK      = 6
+	SPH_RANK_SPH04          = 7
+	SPH_RANK_EXPR           = 8
+	SPH_RANK_EXPORT         = 9
 
 	// known sort modes
 	SPH_SORT_RELEVANCE     = 0
@@ -58,9 +62,11 @@ const (
 	SPH_SORT_EXPR          = 5
 
 	//known filter types
-	SPH_FILTER_VALUES     = 0
-	SPH_FILTER_RANGE      = 1
-	SPH_FILTER_FLOATRANGE = 2
+	SPH_FILTER_VALUES      = 0
+	SPH_FILTER_RANGE       = 1
+	SPH_FILTER_FLOATRANGE  = 2
+	SPH_FILTER_STRING      = 3
+	SPH_FILTER_STRING_LIST = 6
 
 	// known attribute types
 	SPH_ATTR_INTEGER   = 1
@@ -68,7 +74,11 @@ const (
 	SPH_ATTR_ORDINAL   = 3
 	SPH_ATTR_BOOL      = 4
 	SPH_ATTR_FLOAT     = 5
+	SPH_ATTR_BIGINT    = 6
+	SPH_ATTR_STRING    = 7
 	SPH_ATTR_MULTI     = 0x40000000
+	SPH_ATTR_MULTI64   = 0x40000002
+	SPH_ATTR_FACTORS   = 1001 // PACKEDFACTORS() 的结果，配合 SPH_RANK_EXPORT 使用
 
 	// known grouping functions
 	SPH_GROUPBY_DAY      = 0
@@ -79,6 +89,60 @@ const (
 	SPH_GROUPBY_ATTRPAIR = 5
 )
 
+// 影响请求布局的 search 协议版本
+const (
+	verSearchOverrides = 0x116 // 0.9.9: attribute overrides, select-list
+	verSearch64Filters = 0x117 // 1.10: 64-bit filter values
+	verSearchRankExpr  = 0x119 // 2.0: ranking expression
+	verSearchFlags     = 0x11E // 2.2: query flags, string filters, outer select
+
+	VER_COMMAND_SEARCH_MIN = 0x113
+)
+
+// searchLayouts encode 能生成的 search 请求布局，按版本从低到高排列
+var searchLayouts = []uint16{VER_COMMAND_SEARCH_MIN, verSearchOverrides, verSearch64Filters, verSearchRankExpr, verSearchFlags}
+
+// searchLayout 返回不高于 ver 的最高布局版本。请求头里的版本必须与请求体的布局一致，
+// 否则服务端会按头里的版本解析一个旧布局的请求体
+func searchLayout(ver int) uint16 {
+	layout := uint16(0)
+	for _, v := range searchLayouts {
+		if int(v) <= ver {
+			layout = v
+		}
+	}
+	return layout
+}
+
+// Dialect searchd 服务端的协议方言，决定 search 请求的编码布局
+type Dialect int
+
+const (
+	// DialectAuto 先按最新的布局发送，服务端版本更低时按它回报的版本降级重发，协商结果会被缓存
+	DialectAuto Dialect = iota
+	DialectSphinx098
+	DialectSphinx099
+	DialectSphinx2
+	// DialectSphinx3 目前只是 DialectSphinx2 的别名，请求编码和响应解码都相同。
+	// DialectManticore 与 DialectSphinx2 的区别只在于允许 SetFilterStringList
+	DialectSphinx3
+	DialectManticore
+)
+
+var dialectVersions = map[Dialect]uint16{
+	DialectAuto:      VER_COMMAND_SEARCH,
+	DialectSphinx098: 0x113,
+	DialectSphinx099: 0x116,
+	DialectSphinx2:   0x11E,
+	// 3.x 和 manticore 仍然接受 2.2 的 api 布局
+	DialectSphinx3:   0x11E,
+	DialectManticore: 0x11E,
+}
+
+// searchd 拒绝过高的客户端版本时的错误信息，例如
+// client version is higher than daemon version (client is v.1.30, daemon is v.1.22)
+var daemonVersionRe = regexp.MustCompile(`daemon is v\.(\d+)\.(\d+)`)
+
 var (
 	ErrNoClient     = errors.New("no sphinx node available")
 	ErrRetry        = errors.New("cannot connect after several retries")
@@ -86,8 +150,54 @@ var (
 	ErrTimeout      = errors.New("timeout")
 	ErrVersions     = errors.New("sphinx service versions no support")
 	ErrParameter    = errors.New("paramete no support")
+	ErrConnLost     = errors.New("sphinx connection lost")
+
+	ErrResponseTooLarge = errors.New("searchd response too large")
 )
 
+// 默认允许的最大响应包长度
+const defaultMaxResponseSize = 64 << 20
+
+// ResponseSizeError 响应头声明的包长度超过了 SetMaxResponseSize 设置的上限，
+// errors.Is 对 ErrResponseTooLarge 成立
+type ResponseSizeError struct {
+	Size  uint32
+	Limit uint32
+}
+
+func (e *ResponseSizeError) Error() string {
+	return fmt.Sprintf("%s: %d bytes, limit %d", ErrResponseTooLarge, e.Size, e.Limit)
+}
+
+func (e *ResponseSizeError) Is(target error) bool {
+	return target == ErrResponseTooLarge
+}
+
+// SearchdError searchd 返回的错误状态。QueryIndex 为批量查询中出错的查询下标，命令级别的错误为 -1。
+// errors.Is 对 ErrRetryMessage 总是成立，状态为 SEARCHD_RETRY 时对 ErrRetry 也成立
+type SearchdError struct {
+	Status     int
+	Message    string
+	QueryIndex int
+}
+
+func (e *SearchdError) Error() string {
+	if e.QueryIndex >= 0 {
+		return fmt.Sprintf("%s: query %d: %s", ErrRetryMessage, e.QueryIndex, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", ErrRetryMessage, e.Message)
+}
+
+func (e *SearchdError) Is(target error) bool {
+	switch target {
+	case ErrRetryMessage:
+		return true
+	case ErrRetry:
+		return e.Status == SEARCHD_RETRY
+	}
+	return false
+}
+
 type Filter struct {
 	Type      int
 	Attr      string
@@ -97,6 +207,18 @@ type Filter struct {
 	Max       uint
 	Min_float float32
 	Max_float float32
+	Strings   []string
+}
+type Anchor struct {
+	AttrLat  string
+	AttrLong string
+	Lat      float32
+	Long     float32
+}
+type Override struct {
+	Attr   string
+	Type   int
+	Values map[uint64]interface{}
 }
 type Indexweight struct {
 	Idx    string
@@ -106,41 +228,8 @@ type Fieldweights struct {
 	Name   string
 	Weight int
 }
-type vars struct {
-	host          string
-	port          int
-	offset        uint
-	limit         uint
-	mode          int
-	ranker        int
-	sort          int
-	sortby        string
-	weights       []int
-	min_id        uint
-	max_id        uint
-	filters       []Filter
-	groupfunc     int
-	groupby       string
-	maxmatches    uint
-	groupsort     string
-	cutoff        uint
-	retrycount    int
-	retrydelay    int
-	groupdistinct string
-	indexweights  []Indexweight
-	maxquerytime  uint
-	fieldweights  []Fieldweights
-	conntimeout   int
-	arrayresult   bool
-	warning       string
-	resq          [][]byte
-}
-type Sphinx struct {
-	vars vars
-	Conn net.Conn
-}
-
 type Result struct {
+	index      int
 	Error      string
 	Warning    string
 	Status     int
@@ -152,642 +241,150 @@ type Result struct {
 	Time       float32
 	Words      map[string]Words
 }
-type Words struct {
-	Docs uint32
-	Hits uint32
-}
-
-type Matches struct {
-	Id     uint64
-	Weight uint32
-	Attrs  map[interface{}][]interface{}
-}
-
-func New() *Sphinx {
-	s := Sphinx{
-		vars: vars{
-			host:          "127.0.0.1",
-			port:          3312,
-			offset:        0,
-			limit:         20,
-			mode:          SPH_MATCH_ALL,
-			ranker:        SPH_RANK_PROXIMITY_BM25,
-			sort:          SPH_SORT_RELEVANCE,
-			sortby:        "",
-			weights:       nil,
-			min_id:        0,
-			max_id:        0,
-			filters:       nil,
-			groupfunc:     SPH_GROUPBY_DAY,
-			groupby:       "",
-			maxmatches:    1000,
-			groupsort:     "@group desc",
-			cutoff:        0,
-			retrycount:    0,
-			retrydelay:    0,
-			groupdistinct: "",
-			indexweights:  nil,
-			maxquerytime:  0,
-			fieldweights:  nil,
-			conntimeout:   2,
-			arrayresult:   false,
-		},
-	}
 
-	return &s
-}
-
-func (s *Sphinx) GetConnTimeout() int {
-	return s.vars.conntimeout
-}
-
-func (s *Sphinx) SetConnTimeout(timeout int) {
-	s.vars.conntimeout = timeout
-}
-
-func (s *Sphinx) SetServer(host string, port int) {
-	s.vars.host = host
-	s.vars.port = port
-}
-
-func (s *Sphinx) connect() (net.Conn, error) {
-	//1.建立一个链接（Dial拨号
-	conn, err := net.DialTimeout("tcp", s.vars.host+":"+strconv.Itoa(s.vars.port),
-		time.Second*time.Duration(s.GetConnTimeout()))
-
-	if err != nil {
-		return nil, fmt.Errorf("%w:%s", ErrNoClient, err.Error())
-	}
-
-	version := make([]byte, 4)
-	io.ReadFull(conn, version)
-
-	if !bytes.Equal(version, []byte{0x01, 0x00, 0x00, 0x00}) {
-		return nil, fmt.Errorf("%w:%s %b", ErrVersions, "Connect response", version)
-	}
-
-	conn.Write([]byte{0x00, 0x00, 0x00, 0x01})
-	s.Conn = conn
-	return conn, nil
-}
-
-func (s *Sphinx) SetLimits(offset uint, limit uint, max uint, cutoff uint) {
-	s.vars.offset = offset
-	s.vars.limit = limit
-	s.vars.maxmatches = max
-	s.vars.cutoff = cutoff
-}
-
-func (s *Sphinx) SetMaxQueryTime(max uint) {
-	s.vars.maxquerytime = max
-}
-
-func (s *Sphinx) SetMatchMode(mode int) error {
-	if mode == SPH_MATCH_ALL || mode == SPH_MATCH_ANY || mode == SPH_MATCH_PHRASE || mode == SPH_MATCH_BOOLEAN ||
-		mode == SPH_MATCH_EXTENDED ||
-		mode == SPH_MATCH_EXTENDED2 {
-		s.vars.mode = mode
-		return nil
-	} else {
-		return fmt.Errorf("%w:%s", ErrParameter, "SetMatchMode")
-	}
-}
-
-func (s *Sphinx) SetRankingMode(ranker int) error {
-	if ranker == SPH_RANK_PROXIMITY_BM25 || ranker == SPH_RANK_BM25 || ranker == SPH_RANK_NONE || ranker == SPH_RANK_WORDCOUNT {
-		s.vars.ranker = ranker
-		return nil
-	} else {
-		return fmt.Errorf("%w:%s", ErrParameter, "SetRankingMode")
-	}
-}
-
-func (s *Sphinx) SetSortMode(mode int, sortby string) error {
-	if mode == SPH_SORT_RELEVANCE || mode == SPH_SORT_ATTR_DESC || mode == SPH_SORT_ATTR_ASC || mode == SPH_SORT_TIME_SEGMENTS ||
-		mode == SPH_SORT_EXTENDED ||
-		mode == SPH_SORT_EXPR {
-		s.vars.sort = mode
-		s.vars.sortby = sortby
+// Err 返回该查询自身的错误，查询成功或只有警告时返回 nil
+func (r Result) Err() error {
+	if r.Status == SEARCHD_OK || r.Status == SEARCHD_WARNING {
 		return nil
 	}
-
-	return fmt.Errorf("%w:%s", ErrParameter, "SetSortMode")
-}
-
-func (s *Sphinx) SetWeights(weights []int) {
-	s.vars.weights = weights
-}
-
-func (s *Sphinx) SetFieldWeights(weights []Fieldweights) {
-	for _, weight := range weights {
-		s.vars.fieldweights = append(s.vars.fieldweights, weight)
-	}
+	return &SearchdError{Status: r.Status, Message: r.Error, QueryIndex: r.index}
 }
 
-func (s *Sphinx) SetIndexWeights(weights []Indexweight) {
-	for _, weight := range weights {
-		s.vars.indexweights = append(s.vars.indexweights, weight)
-	}
+type Words struct {
+	Docs uint32
+	Hits uint32
 }
 
-func (s *Sphinx) SetIDRange(min uint, max uint) error {
-	if min >= max {
-		return fmt.Errorf("%s, %w: [%d >= %d]", "SetIDRange", ErrParameter, min, max)
-	} else {
-		s.vars.min_id = min
-		s.vars.max_id = max
-		return nil
+// Matches 单条匹配结果。Attrs 中的值按属性类型解码为
+// uint32、int64、float32、string、[]uint32、[]uint64 或 []byte，一般通过 Int/Float/MVA/String/Factors 读取
+type Matches struct {
+	Id      uint64
+	Weight  uint32
+	GeoDist float32
+	Attrs   map[string]interface{}
+}
+
+// Int 返回整数类属性（integer、timestamp、ordinal、bool、bigint）的值，不存在时返回 0
+func (m Matches) Int(name string) int64 {
+	switch v := m.Attrs[name].(type) {
+	case uint32:
+		return int64(v)
+	case int64:
+		return v
 	}
-}
-
-func (s *Sphinx) SetFilter(attribute string, values []int, exclude bool) {
-	f := Filter{
-		Type:    SPH_FILTER_VALUES,
-		Attr:    attribute,
-		Exclude: exclude,
-		Values:  values,
+	return 0
+}
+
+// Float 返回 float 属性的值，不存在时返回 0
+func (m Matches) Float(name string) float32 {
+	switch v := m.Attrs[name].(type) {
+	case float32:
+		return v
+	case uint32:
+		return float32(v)
+	case int64:
+		return float32(v)
 	}
-	s.vars.filters = append(s.vars.filters, f)
+	return 0
 }
 
-func (s *Sphinx) SetFilterRange(attribute string, min uint, max uint, exclude bool) error {
-	if min >= max {
-		return fmt.Errorf("%s, %w: [%d >= %d]", "SetFilterRange", ErrParameter, min, max)
-	}
-
-	f := Filter{
-		Type:    SPH_FILTER_RANGE,
-		Attr:    attribute,
-		Exclude: exclude,
-		Min:     min,
-		Max:     max,
-	}
-	s.vars.filters = append(s.vars.filters, f)
-	return nil
-}
-
-func (s *Sphinx) SetFilterFloatRange(attribute string, min float32, max float32, exclude bool) error {
-
-	if min >= max {
-		return fmt.Errorf("%s, %w: [%d >= %d]", "SetFilterFloatRange", ErrParameter, min, max)
-	}
-
-	f := Filter{
-		Type:      SPH_FILTER_FLOATRANGE,
-		Attr:      attribute,
-		Exclude:   exclude,
-		Min_float: min,
-		Max_float: max,
+// MVA 返回多值属性的值，32 位和 64 位 mva 都以 []uint64 返回
+func (m Matches) MVA(name string) []uint64 {
+	switch v := m.Attrs[name].(type) {
+	case []uint32:
+		vals := make([]uint64, len(v))
+		for i, vv := range v {
+			vals[i] = uint64(vv)
+		}
+		return vals
+	case []uint64:
+		return v
 	}
-	s.vars.filters = append(s.vars.filters, f)
-
 	return nil
 }
 
-func (s *Sphinx) SetGeoAnchor() {
-	//TODO 下次一定
-}
-
-func (s *Sphinx) SetGroupBy(attribute string, fun int, groupsort string) error {
-
-	if fun == SPH_GROUPBY_DAY || fun == SPH_GROUPBY_WEEK || fun == SPH_GROUPBY_MONTH || fun == SPH_GROUPBY_YEAR ||
-		fun == SPH_GROUPBY_ATTR ||
-		fun == SPH_GROUPBY_ATTRPAIR {
-		s.vars.groupby = attribute
-		s.vars.groupfunc = fun
-		s.vars.groupsort = groupsort
-		return nil
-	} else {
-		return fmt.Errorf(" %w: %s", ErrParameter, "SetGroupBy")
-	}
-
-}
-
-func (s *Sphinx) SetGroupDistinct(attribute string) {
-	s.vars.groupdistinct = attribute
-}
-
-func (s *Sphinx) SetRetries(count int, delay int) {
-	s.vars.retrycount = count
-	s.vars.retrydelay = delay
+// String 返回字符串属性的值，不存在时返回空串
+func (m Matches) String(name string) string {
+	v, _ := m.Attrs[name].(string)
+	return v
 }
 
-func (s *Sphinx) SetArrayResult(arrayresult bool) {
-	s.vars.arrayresult = arrayresult
+// Factors 返回 PACKEDFACTORS() 的原始二进制数据，不存在时返回 nil
+func (m Matches) Factors(name string) []byte {
+	v, _ := m.Attrs[name].([]byte)
+	return v
 }
 
-func (s *Sphinx) ResetFilters() {
-	s.vars.filters = []Filter{}
+// Sphinx 保留原来的单对象接口：连接相关的方法来自内嵌的 Client，查询设置来自内嵌的 SearchRequest，
+// AddQuery 把当前设置的副本加入队列。Sphinx 本身不能在多个 goroutine 中共用，
+// 需要并发时共用一个 Client，每个 goroutine 使用自己的 SearchRequest
+type Sphinx struct {
+	*Client
+	SearchRequest
+	resq []SearchRequest
 }
 
-func (s *Sphinx) ResetGroupBy() {
-	s.vars.groupby = ""
-	s.vars.groupfunc = SPH_GROUPBY_DAY
-	s.vars.groupsort = "@group desc"
-	s.vars.groupdistinct = ""
+func New() *Sphinx {
+	return &Sphinx{
+		Client:        NewClient(),
+		SearchRequest: NewSearchRequest("", ""),
+	}
 }
 
+// Query 执行单条查询，不影响 AddQuery 已经加入队列的查询
 func (s *Sphinx) Query(query string, index string, comment string) (Result, error) {
+	return s.QueryContext(context.Background(), query, index, comment)
+}
 
-	conn, err := s.connect()
-	if err != nil {
-		panic(any(err))
-	}
-	defer conn.Close()
+// QueryContext 同 Query，ctx 取消时中断连接和读写，ctx 的截止时间同时用作 searchd 端的 max_query_time
+func (s *Sphinx) QueryContext(ctx context.Context, query string, index string, comment string) (Result, error) {
 
-	s.vars.resq = nil
-	s.AddQuery(query, index, comment)
-	reqs, err := s.runQueries()
+	reqs, err := s.SearchContext(ctx, s.newRequest(query, index, comment))
 	if err != nil {
 		return Result{}, err
 	}
-	s.vars.warning = reqs[0].Warning
-	if reqs[0].Status == SEARCHD_ERROR {
-		return Result{}, errors.New(reqs[0].Error)
+	if err := reqs[0].Err(); err != nil {
+		return Result{}, err
 	}
 
 	return reqs[0], nil
 }
 
-func (s *Sphinx) runQueries() ([]Result, error) {
-
-	//header
-	// 4字节 （(known searchd commands) + （current client-side command implementation versions））
-
-	headr := bytes.NewBuffer([]byte{})
-	binary.Write(headr, binary.BigEndian, uint16(SEARCHD_COMMAND_SEARCH))
-	command_search, _ := hex.DecodeString(VER_COMMAND_SEARCH)
-	binary.Write(headr, binary.BigEndian, command_search)
-
-	resqBuff := bytes.NewBuffer([]byte{})
-
-	for _, v := range s.vars.resq {
-		resqBuff.Write(v)
-	}
-
-	binary.Write(headr, binary.BigEndian, uint32(len(resqBuff.Bytes())+4))
-	nreqs := len(s.vars.resq)
-	binary.Write(headr, binary.BigEndian, uint32(nreqs))
-
-	headr.Write(resqBuff.Bytes())
-
-	s.Conn.Write(headr.Bytes())
-
-	response, err := s.getResponse(VER_COMMAND_SEARCH)
-
-	if err != nil {
-		return nil, err
-	}
-
-	//parse response
-	max := len(response)
-	p := 0
-	results := []Result{}
-
-	for ires := 0; ires < nreqs && p < max; ires++ {
-		result := Result{Matches: map[interface{}]Matches{}, Words: map[string]Words{}}
-		// extract status
-		status := binary.BigEndian.Uint32(response[p : p+4])
-		p += 4
-		result.Status = int(status)
-
-		if status != SEARCHD_OK {
-
-			l := binary.BigEndian.Uint32(response[p : p+4])
-			p += 4
-			message := response[p : p+int(l)]
-			p += int(l)
-
-			if status != SEARCHD_WARNING {
-				result.Warning = string(message)
-			} else {
-				result.Error = string(message)
-				results = append(results, result)
-				continue
-			}
-
-		}
-
-		//read schema
-		fields := []string{}
-		attrs := map[string]uint32{}
-		nfields := binary.BigEndian.Uint32(response[p : p+4])
-		p += 4
-
-		//fields
-		for ; int(nfields) > 0 && p < max; nfields-- {
-
-			l := binary.BigEndian.Uint32(response[p : p+4])
-			p += 4
-			fields = append(fields, string(response[p:p+int(l)]))
-			p += int(l)
-		}
-
-		result.Fields = fields
-
-		//attrs
-		nattrs := binary.BigEndian.Uint32(response[p : p+4])
-		p += 4
-
-		attrsOrder := []string{}
-		for ; int(nattrs) > 0 && p < max; nattrs-- {
-
-			l := binary.BigEndian.Uint32(response[p : p+4])
-			p += 4
-			attr := string(response[p : p+int(l)])
-			p += int(l)
-
-			t := binary.BigEndian.Uint32(response[p : p+4])
-			p += 4
-
-			attrsOrder = append(attrsOrder, attr)
-			attrs[attr] = t
-		}
-		result.Attrs = attrs
-
-		// read match count matches
-
-		count := int(binary.BigEndian.Uint32(response[p : p+4]))
-		p += 4
-		id64 := int(binary.BigEndian.Uint32(response[p : p+4]))
-		p += 4
-
-		var doc uint64
-		var weight uint32
-
-		//matches
-		idx := -1
-		for ; count > 0 && p < max; count-- {
-			idx++
-
-			if id64 == 1 {
-				doc = binary.BigEndian.Uint64(response[p : p+8])
-				p += 8
-			} else {
-				doc = uint64(binary.BigEndian.Uint32(response[p : p+4]))
-				p += 4
-			}
-
-			weight = binary.BigEndian.Uint32(response[p : p+4])
-			p += 4
-
-			attrvals := map[interface{}][]interface{}{}
-
-			for _, attr := range attrsOrder {
-				tp := attrs[attr]
-				if tp == SPH_ATTR_FLOAT {
-					uval := binary.BigEndian.Uint32(response[p : p+4])
-					p += 4
-					attrvals[attr] = append(attrvals[attr], uval)
-					continue
-				}
-
-				val := binary.BigEndian.Uint32(response[p : p+4])
-				p += 4
-
-				if (tp & SPH_ATTR_MULTI) > 0 {
-					attrvals[attr] = []interface{}{}
-
-					for ; val > 0 && (p+4) < max; val-- {
-						attrv := binary.BigEndian.Uint32(response[p : p+4])
-						p += 4
-						attrvals[attr] = append(attrvals[attr], attrv)
-					}
-
-				} else {
-					attrvals[attr] = append(attrvals[attr], val)
-				}
-
-			}
-
-			// create match entry
-			if s.vars.arrayresult {
-				result.Matches[idx] = Matches{Id: doc, Weight: weight, Attrs: attrvals}
-			} else {
-				result.Matches[doc] = Matches{Weight: weight, Attrs: attrvals}
-			}
-		}
-
-		total := binary.BigEndian.Uint32(response[p : p+4])
-		p += 4
-		total_found := binary.BigEndian.Uint32(response[p : p+4])
-		p += 4
-		msecs := float32(binary.BigEndian.Uint32(response[p : p+4]))
-		p += 4
-		words := int(binary.BigEndian.Uint32(response[p : p+4]))
-		p += 4
-
-		for ; words > 0 && p < max; words-- {
-			l := int(binary.BigEndian.Uint32(response[p : p+4]))
-			p += 4
-			word := string(response[p : p+l])
-			p += l
-			docs := binary.BigEndian.Uint32(response[p : p+4])
-			p += 4
-			hits := binary.BigEndian.Uint32(response[p : p+4])
-			p += 4
-			result.Words[word] = Words{
-				Docs: docs,
-				Hits: hits,
-			}
-		}
-
-		result.Total = total
-		result.TotalFound = total_found
-		result.Time = msecs / 1000
-
-		results = append(results, result)
-
-	}
-
-	return results, nil
+// newRequest 以当前设置生成一条查询
+func (s *Sphinx) newRequest(query string, index string, comment string) SearchRequest {
+	q := s.SearchRequest.Clone()
+	q.query = query
+	q.index = index
+	q.comment = comment
+	return q
 }
 
 func (s *Sphinx) AddQuery(query string, index string, comment string) int {
-	//$this->_offset, $this->_limit, $this->_mode, $this->_ranker, $this->_sort
-	buff := bytes.NewBuffer([]byte{})
-	binary.Write(buff, binary.BigEndian, int32(s.vars.offset))
-	binary.Write(buff, binary.BigEndian, int32(s.vars.limit))
-	binary.Write(buff, binary.BigEndian, int32(s.vars.mode))
-	binary.Write(buff, binary.BigEndian, int32(s.vars.ranker))
-	binary.Write(buff, binary.BigEndian, int32(s.vars.sort))
-	//$req .= pack ( "N", strlen($this->_sortby) ) . $this->_sortby;
-	binary.Write(buff, binary.BigEndian, int32(len(s.vars.sortby)))
-	buff.Write([]byte(s.vars.sortby))
-	//$req .= pack ( "N", strlen($query) ) . $query
-	binary.Write(buff, binary.BigEndian, int32(len(query)))
-	buff.Write([]byte(query))
-
-	/*	$req .= pack ( "N", count($this->_weights) ); // weights
-		foreach ( $this->_weights as $weight )
-		$req .= pack ( "N", (int)$weight );*/
-
-	binary.Write(buff, binary.BigEndian, int32(len(s.vars.weights)))
-	for _, v := range s.vars.weights {
-		binary.Write(buff, binary.BigEndian, int32(v))
-	}
-
-	//$req .= pack ( "N", strlen($index) ) . $index; // indexes
-
-	binary.Write(buff, binary.BigEndian, int32(len(index)))
-	buff.Write([]byte(index))
-
-	//$req .= pack ( "N", 1 ); // id64 range marker
-	binary.Write(buff, binary.BigEndian, int32(1))
-
-	//$req .= sphPack64 ( $this->_min_id ) . sphPack64 ( $this->_max_id ); // id64 range
-	binary.Write(buff, binary.BigEndian, int64(s.vars.min_id))
-	binary.Write(buff, binary.BigEndian, int64(s.vars.max_id))
-
-	// filters
-	//$req .= pack ( "N", count($this->_filters) );
-
-	binary.Write(buff, binary.BigEndian, int32(len(s.vars.filters)))
-
-	for _, v := range s.vars.filters {
-		//$req .= pack ( "N", strlen($filter["attr"]) ) . $filter["attr"];
-		binary.Write(buff, binary.BigEndian, int32(len(v.Attr)))
-		buff.Write([]byte(v.Attr))
-		//$req .= pack ( "N", $filter["type"] );
-		binary.Write(buff, binary.BigEndian, int32(v.Type))
-
-		switch v.Type {
-		case SPH_FILTER_VALUES:
-			//$req .= pack ( "N", count($filter["values"]) );
-			binary.Write(buff, binary.BigEndian, int32(len(v.Values)))
-
-			for _, vv := range v.Values {
-				binary.Write(buff, binary.BigEndian, int32(vv))
-			}
-			break
-		case SPH_FILTER_RANGE:
-			//$req .= pack ( "NN", $filter["min"], $filter["max"] );
-			binary.Write(buff, binary.BigEndian, int32(v.Min))
-			binary.Write(buff, binary.BigEndian, int32(v.Max))
-			break
-		case SPH_FILTER_FLOATRANGE:
-			//$req .= $this->_PackFloat ( $filter["min"] ) . $this->_PackFloat ( $filter["max"] );
-			binary.Write(buff, binary.BigEndian, v.Min_float)
-			binary.Write(buff, binary.BigEndian, v.Max_float)
-			break
-		default:
-			{
-			}
-			break
-		}
-
-		if v.Exclude {
-			binary.Write(buff, binary.BigEndian, int32(1))
-		} else {
-			binary.Write(buff, binary.BigEndian, int32(0))
-		}
-
-	}
-
-	//	$req .= pack ( "NN", $this->_groupfunc, strlen($this->_groupby) ) . $this->_groupby;
-	binary.Write(buff, binary.BigEndian, int32(s.vars.groupfunc))
-	binary.Write(buff, binary.BigEndian, int32(len(s.vars.groupby)))
-	buff.Write([]byte(s.vars.groupby))
-
-	//$req .= pack ( "N", $this->_maxmatches );
-	binary.Write(buff, binary.BigEndian, int32(s.vars.maxmatches))
-
-	//$req .= pack ( "N", strlen($this->_groupsort) ) . $this->_groupsort;
-	binary.Write(buff, binary.BigEndian, int32(len(s.vars.groupsort)))
-	buff.Write([]byte(s.vars.groupsort))
-
-	//$req .= pack ( "NNN", $this->_cutoff, $this->_retrycount, $this->_retrydelay );
-	binary.Write(buff, binary.BigEndian, int32(s.vars.cutoff))
-	binary.Write(buff, binary.BigEndian, int32(s.vars.retrycount))
-	binary.Write(buff, binary.BigEndian, int32(s.vars.retrydelay))
-
-	//$req .= pack ( "N", strlen($this->_groupdistinct) ) . $this->_groupdistinct;
-	binary.Write(buff, binary.BigEndian, int32(len(s.vars.groupdistinct)))
-	buff.Write([]byte(s.vars.groupdistinct))
-
-	// anchor point
-	//$req .= pack ( "N", 0 );
-	binary.Write(buff, binary.BigEndian, int32(0))
-
-	// anchor point
-	//$req .= pack ( "N", count($this->_indexweights) );
-	//binary.Write(buff, binary.BigEndian, int32(len(indexweights)))
-
-	// per-index weights
-	binary.Write(buff, binary.BigEndian, int32(len(s.vars.indexweights)))
-
-	for _, v := range s.vars.indexweights {
-		//$req .= pack ( "N", strlen($idx) ) . $idx . pack ( "N", $weight );
-		binary.Write(buff, binary.BigEndian, int32(len(v.Idx)))
-		buff.Write([]byte(v.Idx))
-		binary.Write(buff, binary.BigEndian, int32(v.Weight))
-
-	}
-
-	//$req .= pack ( "N", $this->_maxquerytime );
-	binary.Write(buff, binary.BigEndian, int32(s.vars.maxquerytime))
-
-	// per-field weights
-	//$req .= pack ( "N", count($this->_fieldweights) );
-	binary.Write(buff, binary.BigEndian, int32(len(s.vars.fieldweights)))
-
-	for _, v := range s.vars.fieldweights {
-		//$req .= pack ( "N", strlen($field) ) . $field . pack ( "N", $weight );
-		binary.Write(buff, binary.BigEndian, int32(len(v.Name)))
-		buff.Write([]byte(v.Name))
-		binary.Write(buff, binary.BigEndian, int32(v.Weight))
-	}
-
-	//$req .= pack ( "N", strlen($comment) ) . $comment;
-	binary.Write(buff, binary.BigEndian, int32(len(comment)))
-	buff.Write([]byte(comment))
-
-	s.vars.resq = append(s.vars.resq, buff.Bytes())
-
-	return len(s.vars.resq)
+	s.resq = append(s.resq, s.newRequest(query, index, comment))
+	return len(s.resq)
 }
 
-func (s *Sphinx) getResponse(client_ver string) ([]byte, error) {
-
-	header := make([]byte, 4)
-
-	io.ReadFull(s.Conn, header[:2])
-	status := binary.BigEndian.Uint16(header[:2])
-
-	io.ReadFull(s.Conn, header[:2])
-	ver := binary.BigEndian.Uint16(header[:2])
-
-	io.ReadFull(s.Conn, header[:4])
-	lens := binary.BigEndian.Uint32(header[:4])
-
-	buff := make([]byte, lens)
-	io.ReadFull(s.Conn, buff[:])
-
-	if status == SEARCHD_WARNING {
-		wlen := binary.BigEndian.Uint32(buff[:4])
-		s.vars.warning = string(buff[4:wlen])
-		fmt.Println(s.vars.warning)
-		return buff[4+wlen:], nil
-	}
-
-	if status == SEARCHD_ERROR {
-		return nil, fmt.Errorf("%w: %s", ErrRetryMessage, string(buff[4:]))
-	}
-
-	if status == SEARCHD_RETRY {
-		return nil, fmt.Errorf("%w: %s", ErrRetryMessage, string(buff[4:]))
-	}
+// ResetQueries 清空 AddQuery 加入的查询队列
+func (s *Sphinx) ResetQueries() {
+	s.resq = nil
+}
 
-	if status != SEARCHD_OK {
-		return nil, fmt.Errorf("%w: %s", ErrRetryMessage, "unknown error")
-	}
+// RunQueries 一次性发送 AddQuery 加入的所有查询，按加入顺序返回每条查询的 Result，
+// 并清空队列。单条查询的失败只体现在它自己 Result 的 Status/Error 上，
+// 只有连接或协议层面的错误才会返回 error
+func (s *Sphinx) RunQueries() ([]Result, error) {
+	return s.RunQueriesContext(context.Background())
+}
 
-	command_search, _ := hex.DecodeString(client_ver)
+// RunQueriesContext 同 RunQueries，ctx 取消时中断连接和读写，ctx 的截止时间同时用作 searchd 端的 max_query_time
+func (s *Sphinx) RunQueriesContext(ctx context.Context) ([]Result, error) {
 
-	if ver < binary.BigEndian.Uint16(command_search) {
-		return nil, fmt.Errorf("%w: %s", ErrRetryMessage, "client_ver error")
+	if len(s.resq) == 0 {
+		return nil, fmt.Errorf("%w:%s", ErrParameter, "no queries defined, issue AddQuery() first")
 	}
 
-	return buff, nil
+	reqs := s.resq
+	s.resq = nil
 
+	return s.SearchContext(ctx, reqs...)
 }
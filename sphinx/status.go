@@ -0,0 +1,150 @@
+package sphinx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// Status searchd 运行状态，时间类字段单位为秒。
+// 服务端未开启统计的项（如 query_cpu 为 OFF）保持零值，原始值都可以在 Raw 中找到
+type Status struct {
+	Uptime            uint64
+	Connections       uint64
+	MaxedOut          uint64
+	CommandSearch     uint64
+	CommandExcerpt    uint64
+	CommandUpdate     uint64
+	CommandDelete     uint64
+	CommandKeywords   uint64
+	CommandPersist    uint64
+	CommandStatus     uint64
+	CommandFlushattrs uint64
+	AgentConnect      uint64
+	AgentRetry        uint64
+	Queries           uint64
+	DistQueries       uint64
+	QueryWall         float64
+	QueryCpu          float64
+	DistWall          float64
+	DistLocal         float64
+	DistWait          float64
+	QueryReads        uint64
+	QueryReadkb       uint64
+	QueryReadtime     float64
+	AvgQueryWall      float64
+	AvgQueryCpu       float64
+	AvgDistWall       float64
+	AvgDistLocal      float64
+	AvgDistWait       float64
+	AvgQueryReads     float64
+	AvgQueryReadkb    float64
+	AvgQueryReadtime  float64
+	Raw               map[string]string
+}
+
+func (st *Status) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"uptime":             &st.Uptime,
+		"connections":        &st.Connections,
+		"maxed_out":          &st.MaxedOut,
+		"command_search":     &st.CommandSearch,
+		"command_excerpt":    &st.CommandExcerpt,
+		"command_update":     &st.CommandUpdate,
+		"command_delete":     &st.CommandDelete,
+		"command_keywords":   &st.CommandKeywords,
+		"command_persist":    &st.CommandPersist,
+		"command_status":     &st.CommandStatus,
+		"command_flushattrs": &st.CommandFlushattrs,
+		"agent_connect":      &st.AgentConnect,
+		"agent_retry":        &st.AgentRetry,
+		"queries":            &st.Queries,
+		"dist_queries":       &st.DistQueries,
+		"query_wall":         &st.QueryWall,
+		"query_cpu":          &st.QueryCpu,
+		"dist_wall":          &st.DistWall,
+		"dist_local":         &st.DistLocal,
+		"dist_wait":          &st.DistWait,
+		"query_reads":        &st.QueryReads,
+		"query_readkb":       &st.QueryReadkb,
+		"query_readtime":     &st.QueryReadtime,
+		"avg_query_wall":     &st.AvgQueryWall,
+		"avg_query_cpu":      &st.AvgQueryCpu,
+		"avg_dist_wall":      &st.AvgDistWall,
+		"avg_dist_local":     &st.AvgDistLocal,
+		"avg_dist_wait":      &st.AvgDistWait,
+		"avg_query_reads":    &st.AvgQueryReads,
+		"avg_query_readkb":   &st.AvgQueryReadkb,
+		"avg_query_readtime": &st.AvgQueryReadtime,
+	}
+}
+
+// Status 获取 searchd 的运行状态计数器
+func (c *Client) Status() (Status, error) {
+	return c.StatusContext(context.Background())
+}
+
+// StatusContext 同 Status，ctx 取消时中断连接和读写
+func (c *Client) StatusContext(ctx context.Context) (Status, error) {
+
+	buff := bytes.NewBuffer([]byte{})
+	binary.Write(buff, binary.BigEndian, int32(1))
+
+	response, _, err := c.request(ctx, SEARCHD_COMMAND_STATUS, VER_COMMAND_STATUS, buff.Bytes())
+	if err != nil {
+		return Status{}, err
+	}
+
+	return parseStatusResponse(response)
+}
+
+// parseStatusResponse 解析 status 命令的响应，每行的前两列为计数器名和值
+func parseStatusResponse(response []byte) (Status, error) {
+
+	r := newReader(response)
+	rows := r.count(4, "rows")
+	cols := r.count(4, "cols")
+	if r.err != nil {
+		return Status{}, r.err
+	}
+	if cols == 0 {
+		rows = 0
+	} else if uint64(rows)*uint64(cols) > uint64(r.remaining()/4) {
+		return Status{}, fmt.Errorf("%w: status table %dx%d exceeds remaining %d bytes", ErrMalformedResponse, rows, cols, r.remaining())
+	}
+
+	st := Status{Raw: map[string]string{}}
+	fields := st.fields()
+
+	for ; rows > 0 && r.err == nil; rows-- {
+		row := make([]string, 0, cols)
+		for c := 0; c < cols && r.err == nil; c++ {
+			row = append(row, r.string("status value"))
+		}
+
+		if r.err != nil {
+			return Status{}, r.err
+		}
+
+		if len(row) < 2 {
+			continue
+		}
+		key, val := row[0], row[1]
+		st.Raw[key] = val
+
+		switch f := fields[key].(type) {
+		case *uint64:
+			if v, err := strconv.ParseUint(val, 10, 64); err == nil {
+				*f = v
+			}
+		case *float64:
+			if v, err := strconv.ParseFloat(val, 64); err == nil {
+				*f = v
+			}
+		}
+	}
+
+	return st, nil
+}
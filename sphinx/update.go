@@ -0,0 +1,134 @@
+package sphinx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// AttrValue UpdateAttributes 中单个属性的新值
+type AttrValue struct {
+	Type  int // SPH_ATTR_INTEGER, SPH_ATTR_FLOAT 或 SPH_ATTR_MULTI
+	Int   uint32
+	Float float32
+	MVA   []uint32
+}
+
+// UpdateOptions UpdateAttributes 的可选参数
+type UpdateOptions struct {
+	// 忽略索引中不存在的属性而不是报错，需要 searchd 支持 update v.1.3
+	IgnoreNonExistent bool
+}
+
+func IntValue(v uint32) AttrValue {
+	return AttrValue{Type: SPH_ATTR_INTEGER, Int: v}
+}
+
+func FloatValue(v float32) AttrValue {
+	return AttrValue{Type: SPH_ATTR_FLOAT, Float: v}
+}
+
+func MVAValue(v ...uint32) AttrValue {
+	return AttrValue{Type: SPH_ATTR_MULTI, MVA: v}
+}
+
+// UpdateAttributes 更新 index 中指定文档的属性值，values 的 key 为文档 id，
+// 每个文档的值与 attrs 按顺序一一对应。返回 searchd 实际更新的文档数
+func (c *Client) UpdateAttributes(index string, attrs []string, values map[uint64][]AttrValue, opts UpdateOptions) (int, error) {
+	return c.UpdateAttributesContext(context.Background(), index, attrs, values, opts)
+}
+
+// UpdateAttributesContext 同 UpdateAttributes，ctx 取消时中断连接和读写
+func (c *Client) UpdateAttributesContext(ctx context.Context, index string, attrs []string, values map[uint64][]AttrValue, opts UpdateOptions) (int, error) {
+
+	// 同一个属性在所有文档里必须是同一种类型，mva 标记是按属性发送的
+	types := make([]int, len(attrs))
+	for i := range types {
+		types[i] = -1
+	}
+
+	for id, entry := range values {
+		if len(entry) != len(attrs) {
+			return 0, fmt.Errorf("%s, %w: doc %d has %d values, expected %d", "UpdateAttributes", ErrParameter, id, len(entry), len(attrs))
+		}
+		for i, v := range entry {
+			if v.Type != SPH_ATTR_INTEGER && v.Type != SPH_ATTR_FLOAT && v.Type != SPH_ATTR_MULTI {
+				return 0, fmt.Errorf("%s, %w: attr %s type %d", "UpdateAttributes", ErrParameter, attrs[i], v.Type)
+			}
+			if types[i] == -1 {
+				types[i] = v.Type
+			} else if types[i] != v.Type {
+				return 0, fmt.Errorf("%s, %w: attr %s mixes value types", "UpdateAttributes", ErrParameter, attrs[i])
+			}
+		}
+	}
+
+	ver := uint16(VER_COMMAND_UPDATE)
+	if opts.IgnoreNonExistent {
+		ver = 0x103
+	}
+
+	buff := bytes.NewBuffer([]byte{})
+
+	//$req = pack ( "N", strlen($index) ) . $index;
+	binary.Write(buff, binary.BigEndian, int32(len(index)))
+	buff.Write([]byte(index))
+
+	//$req .= pack ( "N", count($attrs) );
+	binary.Write(buff, binary.BigEndian, int32(len(attrs)))
+
+	if ver >= 0x103 {
+		//$req .= pack ( "N", $ignorenonexistent ? 1 : 0 );
+		binary.Write(buff, binary.BigEndian, int32(1))
+	}
+
+	for i, attr := range attrs {
+		//$req .= pack ( "N", strlen($attr) ) . $attr;
+		binary.Write(buff, binary.BigEndian, int32(len(attr)))
+		buff.Write([]byte(attr))
+		//$req .= pack ( "N", $mva ? 1 : 0 );
+		if types[i] == SPH_ATTR_MULTI {
+			binary.Write(buff, binary.BigEndian, int32(1))
+		} else {
+			binary.Write(buff, binary.BigEndian, int32(0))
+		}
+	}
+
+	//$req .= pack ( "N", count($values) );
+	binary.Write(buff, binary.BigEndian, int32(len(values)))
+
+	for id, entry := range values {
+		//$req .= sphPackU64 ( $id );
+		binary.Write(buff, binary.BigEndian, id)
+
+		for _, v := range entry {
+			switch v.Type {
+			case SPH_ATTR_MULTI:
+				binary.Write(buff, binary.BigEndian, int32(len(v.MVA)))
+				for _, vv := range v.MVA {
+					binary.Write(buff, binary.BigEndian, vv)
+				}
+			case SPH_ATTR_FLOAT:
+				binary.Write(buff, binary.BigEndian, math.Float32bits(v.Float))
+			default:
+				binary.Write(buff, binary.BigEndian, v.Int)
+			}
+		}
+	}
+
+	response, _, err := c.request(ctx, SEARCHD_COMMAND_UPDATE, ver, buff.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	//list(,$updated) = unpack ( "N*", substr ( $response, 0, 4 ) );
+	r := newReader(response)
+	updated := r.uint32("updated")
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	return int(updated), nil
+}
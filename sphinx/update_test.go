@@ -0,0 +1,48 @@
+package sphinx
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUpdateAttributesEncoding(t *testing.T) {
+	c, last := captureSearchd(t, func(body []byte) []byte {
+		return (&packet{}).u32(1).Bytes()
+	})
+
+	values := map[uint64][]AttrValue{
+		1 << 40: {IntValue(7), FloatValue(9.5), MVAValue(1, 2)},
+	}
+	n, err := c.UpdateAttributes("test", []string{"group_id", "price", "tags"}, values, UpdateOptions{IgnoreNonExistent: true})
+	if err != nil || n != 1 {
+		t.Fatalf("updated %d: %v", n, err)
+	}
+
+	want := &packet{}
+	want.str("test")
+	want.u32(3, 1)
+	want.str("group_id").u32(0)
+	want.str("price").u32(0)
+	want.str("tags").u32(1)
+	want.u32(1)
+	want.u64(1<<40).u32(7, 0x41180000, 2, 1, 2)
+
+	cmd, ver, body := last()
+	if cmd != SEARCHD_COMMAND_UPDATE || ver != 0x103 {
+		t.Fatalf("command %d version 0x%x", cmd, ver)
+	}
+	if !bytes.Equal(body, want.Bytes()) {
+		t.Fatalf("body\n%x\nwant\n%x", body, want.Bytes())
+	}
+
+	// 不忽略不存在的属性时按旧版本发送，请求中没有 ignore 标记
+	if _, err := c.UpdateAttributes("test", []string{"group_id"}, map[uint64][]AttrValue{1: {IntValue(7)}}, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want = &packet{}
+	want.str("test").u32(1).str("group_id").u32(0)
+	want.u32(1).u64(1).u32(7)
+	if _, ver, body = last(); ver != VER_COMMAND_UPDATE || !bytes.Equal(body, want.Bytes()) {
+		t.Fatalf("version 0x%x body\n%x\nwant\n%x", ver, body, want.Bytes())
+	}
+}
@@ -12,7 +12,7 @@ func main() {
 	s.SetMatchMode(sphinx.SPH_MATCH_ANY)
 	//s.SetSortMode(sphinx.SPH_SORT_EXTENDED, "pr desc")
 	//s.SetArrayResult(true)
-	s.SetFieldWeights([]sphinx.Fieldweights{{"title", 999}, {"keyword", 100}})
+	s.SetFieldWeights([]sphinx.Fieldweights{{Name: "title", Weight: 999}, {Name: "keyword", Weight: 100}})
 	//s.SetFilter("c1", []int{1}, true)
 	s.SetFilterRange("picid_i", uint(881642), uint(881645), false)
 	req, err := s.Query("美女", "name", "")